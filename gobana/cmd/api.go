@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/joernott/elasticsearch-tools/gobana/handler"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var FilterPath []string
+var Raw bool
+
+var apiCmd = &cobra.Command{
+	Use:   "api METHOD PATH [BODY]",
+	Short: "Send a request to any API",
+	Long: `Send a request to any API of the cluster using the connection settings and print
+the response body, json is pretty printed. The status of failed requests is printed
+on stderr.
+BODY is the request body, @FILE reads it from a file and - from stdin.`,
+	Args: cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd)
+		method := strings.ToUpper(args[0])
+		if !slices.Contains(handler.Methods, method) {
+			fmt.Fprintln(os.Stderr, "Unsupported method '"+args[0]+"', use one of "+strings.Join(handler.Methods, ", "))
+			os.Exit(ExitSetup)
+		}
+		path := args[1]
+		if strings.Contains(path, "://") {
+			fmt.Fprintln(os.Stderr, "PATH must be a path like /_cluster/health, the server is taken from the configuration")
+			os.Exit(ExitSetup)
+		}
+		if filterPath := viper.GetStringSlice("api-filter-path"); len(filterPath) > 0 {
+			path = handler.AddParam(path, "filter_path", strings.Join(filterPath, ","))
+		}
+		var body []byte
+		if len(args) > 2 {
+			var err error
+			body, err = readBody(args[2])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(ExitSetup)
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		response, err := handler.Request(ctx, connect(), method, path, body)
+		if response != nil {
+			if response.Status != 0 {
+				fmt.Fprintf(os.Stderr, "%d %s\n", response.Status, http.StatusText(response.Status))
+			}
+			if viper.GetBool("api-raw") {
+				os.Stdout.Write(response.Body)
+			} else {
+				printBody(response.Body)
+			}
+		}
+		if err != nil {
+			stop()
+			exitOnQueryError(err)
+		}
+	},
+}
+
+// readBody returns the body given on the command line, read from a file
+// for @FILE and from stdin for -.
+func readBody(Body string) ([]byte, error) {
+	switch {
+	case Body == "-":
+		return io.ReadAll(os.Stdin)
+	case strings.HasPrefix(Body, "@"):
+		return os.ReadFile(Body[1:])
+	}
+	return []byte(Body), nil
+}
+
+func init() {
+	apiCmd.Flags().StringSliceVar(&FilterPath, "filter-path", []string{}, "Return only these fields of the response, e.g. hits.hits._source,aggregations")
+	apiCmd.Flags().BoolVar(&Raw, "raw", false, "Output the response body as received instead of pretty printing json")
+	viper.SetDefault("api-filter-path", []string{})
+	viper.SetDefault("api-raw", false)
+	viper.BindPFlag("api-filter-path", apiCmd.Flags().Lookup("filter-path"))
+	viper.BindPFlag("api-raw", apiCmd.Flags().Lookup("raw"))
+	rootCmd.AddCommand(apiCmd)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBody(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "body.json")
+	if err := os.WriteFile(file, []byte(`{"size":1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin.WriteString(`{"size":2}`)
+	stdin.Seek(0, 0)
+	saved := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = saved }()
+
+	tests := []struct {
+		arg  string
+		want string
+		err  bool
+	}{
+		{`{"size":0}`, `{"size":0}`, false},
+		{"@" + file, `{"size":1}`, false},
+		{"-", `{"size":2}`, false},
+		{"@" + file + ".missing", "", true},
+	}
+	for _, test := range tests {
+		body, err := readBody(test.arg)
+		if (err != nil) != test.err || string(body) != test.want {
+			t.Errorf("readBody(%s) = %q, %v, want %q", test.arg, body, err, test.want)
+		}
+	}
+}
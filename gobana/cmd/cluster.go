@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/joernott/elasticsearch-tools/gobana/handler"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search [INDEX]",
+	Short: "Search the index",
+	Long: `Search the index (pattern) with the query flags and output the hits as table,
+or in the format given by --output`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			viper.Set("endpoint", args[0]+"/_search")
+		}
+		if viper.GetString("output") == "" && len(viper.GetStringSlice("singlevalue")) == 0 && viper.GetString("aggregation") == "" {
+			viper.Set("output", handler.OutputTable)
+		}
+		runQuery(cmd, args)
+	},
+}
+
+var countCmd = &cobra.Command{
+	Use:   "count [INDEX]",
+	Short: "Count the documents matching the query",
+	Long: `Count the documents in the index (pattern) matching the query flags, all documents without query.
+With --watch the count is repeated every interval, the query is created again for every run.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd, append(append(queryFlags, recordFlags...), watchFlags...)...)
+		endpoint := "_count"
+		if len(args) > 0 {
+			endpoint = args[0] + "/_count"
+		}
+		connection := connect()
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if viper.GetString("watch") != "" {
+			err := watchRecords(ctx, func(ctx context.Context) ([]handler.Record, error) {
+				g, err := newGobana(connection, endpoint)
+				if err != nil {
+					return nil, err
+				}
+				return countRecords(ctx, g)
+			})
+			if err != nil {
+				stop()
+				os.Exit(exitCode(err))
+			}
+			return
+		}
+		g, err := newGobana(connection, endpoint)
+		if err != nil {
+			os.Exit(ExitSetup)
+		}
+		records, err := countRecords(ctx, g)
+		if err != nil {
+			stop()
+			exitOnQueryError(err)
+		}
+		writeRecords(records)
+	},
+}
+
+// countRecords counts the documents matching the query of g and returns
+// the count as a single record.
+func countRecords(ctx context.Context, g *handler.Gobana) ([]handler.Record, error) {
+	count, err := g.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []handler.Record{{
+		Fields: []string{"count"},
+		Values: map[string]interface{}{"count": count},
+	}}, nil
+}
+
+var getCmd = &cobra.Command{
+	Use:   "get INDEX ID",
+	Short: "Get a document by its id",
+	Long: `Get a document by its id. Table, csv and tsv output show one row per field of
+the document, json, ndjson and yaml output the document.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd, recordFlags...)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		document, err := handler.GetDocument(ctx, connect(), args[0], args[1])
+		if err != nil {
+			stop()
+			exitOnQueryError(err)
+		}
+		switch strings.ToLower(viper.GetString("output")) {
+		case handler.OutputJson, handler.OutputNdjson, handler.OutputYaml:
+			writeRecords([]handler.Record{handler.NewRecord(document)})
+		default:
+			writeRecords(handler.DocumentRecords(document))
+		}
+	},
+}
+
+var indicesCmd = &cobra.Command{
+	Use:   "indices [PATTERN]",
+	Short: "List the indices",
+	Long:  `List the indices matching the pattern, all indices without pattern`,
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runCat("indices"),
+}
+
+var healthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "Show the cluster health",
+	Args:  cobra.NoArgs,
+	Run:   runCat("health"),
+}
+
+var nodesCmd = &cobra.Command{
+	Use:   "nodes",
+	Short: "List the nodes of the cluster",
+	Args:  cobra.NoArgs,
+	Run:   runCat("nodes"),
+}
+
+var shardsCmd = &cobra.Command{
+	Use:   "shards [PATTERN]",
+	Short: "List the shards",
+	Long:  `List the shards of the indices matching the pattern, all shards without pattern`,
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runCat("shards"),
+}
+
+var aliasesCmd = &cobra.Command{
+	Use:   "aliases [PATTERN]",
+	Short: "List the aliases",
+	Long:  `List the aliases matching the pattern, all aliases without pattern`,
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runCat("aliases"),
+}
+
+var mappingCmd = &cobra.Command{
+	Use:   "mapping [PATTERN]",
+	Short: "List the fields of the mappings",
+	Long:  `List the fields and their types of the indices matching the pattern, all indices without pattern`,
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd, recordFlags...)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		records, err := handler.Mapping(ctx, connect(), pattern(args))
+		if err != nil {
+			stop()
+			exitOnQueryError(err)
+		}
+		writeRecords(records)
+	},
+}
+
+var settingsCmd = &cobra.Command{
+	Use:   "settings [PATTERN]",
+	Short: "List the index settings",
+	Long:  `List the settings of the indices matching the pattern, all indices without pattern`,
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd, recordFlags...)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		records, err := handler.IndexSettings(ctx, connect(), pattern(args))
+		if err != nil {
+			stop()
+			exitOnQueryError(err)
+		}
+		writeRecords(records)
+	},
+}
+
+// runCat returns the Run function of the subcommand for a cat API. The
+// columns and sort order default to handler.CatColumns and handler.CatSort.
+// With --watch the list is fetched again every interval.
+func runCat(Api string) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd, append(append(recordFlags, watchFlags...), "sort")...)
+		connection := connect()
+		cat := func(ctx context.Context) ([]handler.Record, error) {
+			return handler.Cat(ctx, connection, Api, pattern(args), viper.GetStringSlice("columns"), viper.GetStringSlice("sort"))
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if viper.GetString("watch") != "" {
+			if err := watchRecords(ctx, cat); err != nil {
+				stop()
+				os.Exit(exitCode(err))
+			}
+			return
+		}
+		records, err := cat(ctx)
+		if err != nil {
+			stop()
+			exitOnQueryError(err)
+		}
+		writeRecords(records)
+	}
+}
+
+// queryFlags are the flags creating the query of commands which don't
+// search, like count.
+var queryFlags = []string{"query", "queryfile", "toml", "data", "datafile", "library", "kql", "lucene", "from", "to", "last", "time-field", "timezone"}
+
+// recordFlags are the flags of the commands writing records with
+// writeRecords.
+var recordFlags = []string{"output", "columns", "noheader"}
+
+// watchFlags are the flags of the commands repeating their request with
+// watchRecords.
+var watchFlags = []string{"watch", "watch-mode"}
+
+// recordFormatter returns the formatter selected by --output writing to
+// Writer, a table if none is selected.
+func recordFormatter(Writer io.Writer) (handler.Formatter, error) {
+	format := viper.GetString("output")
+	if format == "" {
+		format = handler.OutputTable
+	}
+	return handler.NewFormatter(format, Writer, viper.GetStringSlice("columns"), !viper.GetBool("noheader"))
+}
+
+// pattern returns the optional index pattern argument.
+func pattern(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+// writeRecords writes the records in the format selected by --output,
+// which defaults to a table.
+func writeRecords(Records []handler.Record) {
+	output, err := recordFormatter(os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitSetup)
+	}
+	for _, record := range Records {
+		if err = output.WriteRecord(record); err != nil {
+			os.Exit(ExitOutput)
+		}
+	}
+	if err = output.Close(); err != nil {
+		os.Exit(ExitOutput)
+	}
+}
+
+func init() {
+	for _, c := range []*cobra.Command{searchCmd, countCmd, getCmd, indicesCmd, healthCmd, nodesCmd, shardsCmd, aliasesCmd, mappingCmd, settingsCmd} {
+		rootCmd.AddCommand(c)
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/joernott/elasticsearch-tools/internal/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage cluster profiles",
+	Long:  `List, select and show the cluster profiles of the clusters section in the configuration file`,
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cluster profiles",
+	Long:  `List the cluster profiles, the default is marked with * and the one selected with --cluster with >`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defaultCluster := config.DefaultCluster()
+		for _, name := range config.ClusterNames() {
+			settings, err := config.Settings(name)
+			if err != nil {
+				os.Exit(ExitSetup)
+			}
+			marker := " "
+			if name == defaultCluster {
+				marker = "*"
+			}
+			if cmd.Flags().Changed("cluster") && name == viper.GetString("cluster") {
+				marker = ">"
+			}
+			fmt.Fprintf(w, "%s %s\t%v:%v\n", marker, name, settings["host"], settings["port"])
+		}
+		w.Flush()
+	},
+}
+
+var configUseCmd = &cobra.Command{
+	Use:   "use CLUSTER",
+	Short: "Make a cluster profile the default",
+	Long:  `Set the cluster setting in the configuration file. Only the cluster line of yaml and toml files is changed.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd)
+		name := args[0]
+		if err := config.UseCluster(name); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitSetup)
+		}
+		fmt.Println("Default cluster is now '" + name + "'")
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show [CLUSTER]",
+	Short: "Show the connection settings of a cluster profile",
+	Long:  `Show the effective connection settings of the selected or the given cluster profile`,
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd)
+		name := viper.GetString("cluster")
+		if len(args) > 0 {
+			name = args[0]
+		}
+		settings, err := config.Settings(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitSetup)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "cluster\t%s\n", name)
+		for _, key := range config.ConnectionSettings {
+			value := settings[key]
+			if key == "password" && fmt.Sprint(value) != "" {
+				value = "********"
+			}
+			fmt.Fprintf(w, "%s\t%v\n", key, value)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configListCmd)
+	configCmd.AddCommand(configUseCmd)
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
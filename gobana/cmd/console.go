@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joernott/elasticsearch-tools/gobana/handler"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ContinueOnError bool
+
+var consoleCmd = &cobra.Command{
+	Use:   "console FILE",
+	Short: "Run the requests of a Kibana Dev Tools file",
+	Long: `Run the requests of a file in the format of the Kibana Dev Tools console in order
+and print each response, failed ones with their status. Use - to read the requests
+from stdin.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd)
+		file := os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(ExitSetup)
+			}
+			defer f.Close()
+			file = f
+		}
+		requests, err := handler.ParseConsole(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitSetup)
+		}
+		connection := connect()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		var firstErr error
+		for i, request := range requests {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("# %s %s\n", request.Method, request.Path)
+			response, err := handler.Request(ctx, connection, request.Method, request.Path, request.Body)
+			if response != nil {
+				if response.Status != 0 {
+					fmt.Printf("# %d %s\n", response.Status, http.StatusText(response.Status))
+				}
+				printBody(response.Body)
+			}
+			if err == nil {
+				continue
+			}
+			if errors.Is(err, context.Canceled) || !viper.GetBool("console-continue-on-error") {
+				stop()
+				exitOnQueryError(err)
+			}
+			fmt.Fprint(os.Stderr, handler.DescribeError(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr != nil {
+			stop()
+			os.Exit(exitCode(firstErr))
+		}
+	},
+}
+
+// printBody prints a json response indented, other responses as they are.
+func printBody(Body []byte) {
+	writeBody(os.Stdout, Body)
+}
+
+// writeBody writes a json response indented to Writer, other responses as
+// they are.
+func writeBody(Writer io.Writer, Body []byte) error {
+	if len(Body) == 0 {
+		return nil
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, Body, "", "  "); err == nil {
+		Body = indented.Bytes()
+	}
+	if Body[len(Body)-1] != '\n' {
+		Body = append(Body, '\n')
+	}
+	_, err := Writer.Write(Body)
+	return err
+}
+
+func init() {
+	consoleCmd.Flags().BoolVar(&ContinueOnError, "continue-on-error", false, "Run the remaining requests after a request failed, the exit code is the one of the first failure")
+	viper.SetDefault("console-continue-on-error", false)
+	viper.BindPFlag("console-continue-on-error", consoleCmd.Flags().Lookup("continue-on-error"))
+	rootCmd.AddCommand(consoleCmd)
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/joernott/elasticsearch-tools/gobana/handler"
+)
+
+// Exit codes of gobana, see README.md
+const (
+	ExitOk             = 0
+	ExitError          = 1
+	ExitLogging        = 10
+	ExitSetup          = 20
+	ExitQuery          = 21
+	ExitOutput         = 22
+	ExitConnection     = 30
+	ExitAuthentication = 31
+	ExitQueryParse     = 32
+	ExitIndexNotFound  = 33
+	ExitPartialShards  = 34
+	ExitTimeout        = 35
+	ExitInterrupted    = 130
+)
+
+// exitCode maps an error returned by a query to the exit code.
+func exitCode(err error) int {
+	if errors.Is(err, context.Canceled) {
+		return ExitInterrupted
+	}
+	switch handler.KindOf(err) {
+	case handler.ErrorConnection:
+		return ExitConnection
+	case handler.ErrorAuthentication:
+		return ExitAuthentication
+	case handler.ErrorQueryParse:
+		return ExitQueryParse
+	case handler.ErrorIndexNotFound:
+		return ExitIndexNotFound
+	case handler.ErrorPartialShards:
+		return ExitPartialShards
+	case handler.ErrorTimeout:
+		return ExitTimeout
+	}
+	return ExitQuery
+}
+
+// exitOnQueryError prints a readable description of the error on stderr
+// and exits with the matching exit code.
+func exitOnQueryError(err error) {
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "Interrupted")
+	} else {
+		fmt.Fprint(os.Stderr, handler.DescribeError(err))
+	}
+	os.Exit(exitCode(err))
+}
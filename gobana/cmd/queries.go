@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/joernott/elasticsearch-tools/gobana/handler"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run NAME",
+	Short: "Run a saved query",
+	Long: `Run the saved query NAME.yml from the query library set with --library. Params are
+passed with -d key=value or --datafile, flags given on the command line override the
+settings of the saved query.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, key := range []string{"query", "queryfile", "kql", "lucene", "template-id", "mustache"} {
+			if cmd.Flags().Changed(key) {
+				fmt.Fprintln(os.Stderr, "Can't use --"+key+" with a saved query")
+				os.Exit(ExitSetup)
+			}
+		}
+		q := loadSavedQuery(args[0])
+		data, err := q.Data(viper.GetStringSlice("data"), viper.GetStringSlice("datafile"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitSetup)
+		}
+		viper.Set("data", data)
+		viper.Set("query", q.Query)
+		viper.Set("queryfile", q.Queryfile)
+		viper.Set("kql", q.KQL)
+		viper.Set("lucene", q.Lucene)
+		viper.Set("template-id", q.TemplateId)
+		viper.Set("mustache", q.Mustache)
+		if q.IsTemplate() {
+			viper.Set("toml", true)
+		}
+		settings := map[string]interface{}{
+			"endpoint":    q.Endpoint,
+			"method":      q.Method,
+			"last":        q.Last,
+			"from":        q.From,
+			"to":          q.To,
+			"time-field":  q.TimeField,
+			"sort":        q.Sort,
+			"fields":      q.Fields,
+			"output":      q.Output,
+			"columns":     q.Columns,
+			"singlevalue": q.SingleValue,
+			"aggregation": q.Aggregation,
+			"flatten":     q.Flatten,
+			"all":         q.All,
+		}
+		if q.Size != nil {
+			settings["size"] = *q.Size
+		}
+		for key, value := range settings {
+			if cmd.Flags().Changed(key) || isEmpty(value) {
+				continue
+			}
+			viper.Set(key, value)
+		}
+		runQuery(cmd, args)
+	},
+}
+
+var queriesCmd = &cobra.Command{
+	Use:   "queries",
+	Short: "List and show saved queries",
+	Long:  `List and show the saved queries of the query library set with --library`,
+}
+
+var queriesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the saved queries",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd, append(recordFlags, "library")...)
+		queries, err := handler.SavedQueries(library())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitSetup)
+		}
+		records := make([]handler.Record, 0, len(queries))
+		for _, q := range queries {
+			params := make([]string, 0, len(q.Params))
+			for _, p := range q.Params {
+				params = append(params, p.Name)
+			}
+			records = append(records, handler.Record{
+				Fields: []string{"name", "description", "params"},
+				Values: map[string]interface{}{
+					"name":        q.Name,
+					"description": q.Description,
+					"params":      strings.Join(params, ","),
+				},
+			})
+		}
+		writeRecords(records)
+	},
+}
+
+var queriesShowCmd = &cobra.Command{
+	Use:   "show NAME",
+	Short: "Show a saved query with its params",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd, "library")
+		q := loadSavedQuery(args[0])
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "name\t%s\n", q.Name)
+		fmt.Fprintf(w, "file\t%s\n", q.File)
+		if q.Description != "" {
+			fmt.Fprintf(w, "description\t%s\n", q.Description)
+		}
+		endpoint := q.Endpoint
+		if endpoint == "" {
+			endpoint = handler.DefaultEndpoint
+		}
+		fmt.Fprintf(w, "endpoint\t%s\n", endpoint)
+		w.Flush()
+		if len(q.Params) > 0 {
+			fmt.Println()
+			w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "param\tdefault\trequired\tdescription")
+			for _, p := range q.Params {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Name, handler.FormatValue(p.Default), strconv.FormatBool(p.Required), p.Description)
+			}
+			w.Flush()
+		}
+		fmt.Println()
+		switch {
+		case q.Query != "":
+			fmt.Println(strings.TrimRight(q.Query, "\n"))
+		case q.Queryfile != "":
+			fmt.Println("queryfile: " + q.Queryfile)
+		case q.KQL != "":
+			fmt.Println("kql: " + q.KQL)
+		case q.Lucene != "":
+			fmt.Println("lucene: " + q.Lucene)
+		case q.TemplateId != "":
+			fmt.Println("template_id: " + q.TemplateId)
+		}
+	},
+}
+
+// library returns the query library directory and exits if none is set.
+func library() string {
+	dir := viper.GetString("library")
+	if dir == "" {
+		fmt.Fprintln(os.Stderr, "No query library, set --library or library in the configuration file")
+		os.Exit(ExitSetup)
+	}
+	return dir
+}
+
+func loadSavedQuery(Name string) *handler.SavedQuery {
+	q, err := handler.LoadSavedQuery(library(), Name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitSetup)
+	}
+	return q
+}
+
+// isEmpty returns true for settings of a saved query which are not set.
+func isEmpty(Value interface{}) bool {
+	switch v := Value.(type) {
+	case string:
+		return v == ""
+	case []string:
+		return len(v) == 0
+	case bool:
+		return !v
+	}
+	return Value == nil
+}
+
+func init() {
+	queriesCmd.AddCommand(queriesListCmd)
+	queriesCmd.AddCommand(queriesShowCmd)
+	rootCmd.AddCommand(queriesCmd)
+	rootCmd.AddCommand(runCmd)
+}
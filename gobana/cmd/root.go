@@ -1,242 +1,491 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
-
-	"bufio"
-	"path/filepath"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 
 	_ "github.com/davecgh/go-spew/spew"
 	"github.com/joernott/elasticsearch-tools/gobana/handler"
+	"github.com/joernott/elasticsearch-tools/gobana/query"
+	"github.com/joernott/elasticsearch-tools/internal/config"
 	"github.com/joernott/lra"
-	homedir "github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
-var Connection *lra.Connection
-
 var rootCmd = &cobra.Command{
 	Use:   "gobana",
 	Short: "Gobana is a commandline kibana",
 	Long:  `A commandline kibana written in go`,
 	PersistentPreRun: func(ccmd *cobra.Command, args []string) {
-		err := HandleConfigFile()
+		err := config.HandleConfigFile()
 		if err != nil {
 			panic(err)
 		}
-		err = InitLogging()
+		err = config.InitLogging()
 		if err != nil {
 			fmt.Println("Error configuring logging")
-			os.Exit(10)
+			os.Exit(ExitLogging)
 		}
-
+		log.Debug("PersistentPreRun finished")
 	},
-	Run: func(cmd *cobra.Command, args []string) {
-		var result *handler.ElasticsearchResult
-
-		g, err := handler.NewGobana(
-			viper.GetBool("ssl"),
-			viper.GetString("host"),
-			viper.GetInt("port"),
-			viper.GetString("user"),
-			viper.GetString("password"),
-			viper.GetBool("validatessl"),
-			viper.GetString("proxy"),
-			viper.GetBool("socks"),
-			viper.GetUint("timeout"),
-			viper.GetString("query"),
-			viper.GetString("queryfile"),
-			viper.GetBool("toml"),
-			viper.GetStringSlice("data"),
-			viper.GetString("endpoint"))
+	Run: runQuery,
+}
+
+// runQuery posts the query to the endpoint and writes the result.
+func runQuery(cmd *cobra.Command, args []string) {
+	g, err := newGobana(connect(), viper.GetString("endpoint"))
+	if err != nil {
+		os.Exit(ExitSetup)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if viper.GetBool("render-only") {
+		if viper.GetString("template-id") == "" && !viper.GetBool("mustache") {
+			fmt.Fprintln(os.Stderr, "--render-only needs --template-id or --mustache")
+			os.Exit(ExitSetup)
+		}
+		rendered, err := g.RenderSearchTemplate(ctx)
 		if err != nil {
-			os.Exit(20)
+			stop()
+			exitOnQueryError(err)
 		}
-		result, err = g.Execute()
+		printBody(rendered)
+		return
+	}
+	if viper.GetString("watch") != "" {
+		err = watchQuery(ctx, g.Connection)
 		if err != nil {
-			os.Exit(21)
+			os.Exit(exitCode(err))
 		}
-		jsonFile := viper.GetString("jsonoutput")
-		if jsonFile != "" {
-			err := result.WriteFile(jsonFile)
-			if err != nil {
-				os.Exit(22)
-			}
+		return
+	}
+	if viper.GetBool("all") {
+		err = executeAll(ctx, g)
+		if err != nil {
+			exitOnQueryError(err)
+		}
+		return
+	}
+	output, err := newFormatter(os.Stdout, true)
+	if err != nil {
+		os.Exit(ExitSetup)
+	}
+	result, err := execute(ctx, g)
+	if err != nil {
+		exitOnQueryError(err)
+	}
+	jsonFile := viper.GetString("jsonoutput")
+	if jsonFile != "" {
+		err := writeJsonFile(jsonFile, result)
+		if err != nil {
+			os.Exit(ExitOutput)
+		}
+	}
+	err = writeResult(os.Stdout, output, result)
+	if err != nil {
+		os.Exit(ExitOutput)
+	}
+}
+
+// execute runs the query, fetching all pages of a composite aggregation
+// selected with --aggregation.
+func execute(ctx context.Context, g *handler.Gobana) (*handler.ElasticsearchResult, error) {
+	if aggregation := viper.GetString("aggregation"); aggregation != "" {
+		return g.ExecuteComposite(ctx, aggregation)
+	}
+	return g.Execute(ctx)
+}
+
+// writeResult writes the hits to the formatter Output or, without one, the
+// single values to Writer, followed by the aggregation. The response of
+// endpoints which don't search, like _cluster/state, and responses which
+// are no json object are written to Writer as they are.
+func writeResult(Writer io.Writer, Output handler.Formatter, result *handler.ElasticsearchResult) error {
+	if isRaw(result) {
+		return writeBody(Writer, result.Body)
+	}
+	if Output != nil {
+		err := result.WriteRecords(Output, outputColumns())
+		if err == nil {
+			err = Output.Close()
 		}
-		fieldName := viper.GetString("singlevalue")
-		if fieldName != "" {
-			result.SingleValue(fieldName)
+		if err != nil {
+			return err
 		}
-		fieldName = viper.GetString("aggregation")
-		if fieldName != "" {
-			result.GetAggregation(fieldName)
+	} else if fieldNames := viper.GetStringSlice("singlevalue"); len(fieldNames) > 0 {
+		err := result.SingleValue(Writer, fieldNames, viper.GetBool("valueonly"))
+		if err != nil {
+			return err
 		}
-	},
+	}
+	return writeAggregation(Writer, result)
+}
+
+// writeJsonFile writes the result to the --jsonoutput file, the response
+// as received if it is no search result.
+func writeJsonFile(FileName string, result *handler.ElasticsearchResult) error {
+	if !isRaw(result) {
+		return result.WriteFile(FileName)
+	}
+	err := os.WriteFile(FileName, result.Body, 0644)
+	if err != nil {
+		log.WithField("func", "writeJsonFile").Error(err)
+	}
+	return err
+}
+
+// isRaw returns true if the result is no search result.
+func isRaw(result *handler.ElasticsearchResult) bool {
+	if !handler.IsSearchEndpoint(viper.GetString("endpoint")) {
+		return true
+	}
+	body := bytes.TrimSpace(result.Body)
+	return len(body) > 0 && body[0] != '{'
+}
+
+// connect creates the connection to Elasticsearch and exits if that
+// fails.
+func connect() *lra.Connection {
+	connection, err := config.NewConnection()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitConnection)
+	}
+	return connection
+}
+
+// connectionFlags are the configuration, connection and logging flags of
+// the root command, which all subcommands use.
+var connectionFlags = make(map[string]bool)
+
+// rejectFlags exits if a query flag of the root command is given which the
+// subcommand doesn't use. Allowed lists the query flags it uses.
+func rejectFlags(cmd *cobra.Command, Allowed ...string) {
+	rootCmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
+		if connectionFlags[flag.Name] || slices.Contains(Allowed, flag.Name) || !cmd.Flags().Changed(flag.Name) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Can't use --"+flag.Name+" with "+cmd.CommandPath())
+		os.Exit(ExitSetup)
+	})
+}
+
+// newGobana creates the query for the endpoint from the query flags.
+func newGobana(Connection *lra.Connection, Endpoint string) (*handler.Gobana, error) {
+	var size *int
+	if n := viper.GetInt("size"); n >= 0 {
+		size = &n
+	}
+	return handler.NewGobana(handler.GobanaOptions{
+		Connection: Connection,
+		Method:     viper.GetString("method"),
+		Endpoint:   Endpoint,
+		Query:      viper.GetString("query"),
+		Queryfile:  viper.GetString("queryfile"),
+		Toml:       viper.GetBool("toml"),
+		Data:       viper.GetStringSlice("data"),
+		DataFiles:  viper.GetStringSlice("datafile"),
+		Library:    viper.GetString("library"),
+		KQL:        viper.GetString("kql"),
+		Lucene:     viper.GetString("lucene"),
+		TemplateId: viper.GetString("template-id"),
+		Mustache:   viper.GetBool("mustache"),
+		TimeRange: query.TimeRange{
+			Field:    viper.GetString("time-field"),
+			From:     viper.GetString("from"),
+			To:       viper.GetString("to"),
+			Last:     viper.GetString("last"),
+			TimeZone: viper.GetString("timezone"),
+		},
+		Size:      size,
+		Sort:      viper.GetStringSlice("sort"),
+		Fields:    viper.GetStringSlice("fields"),
+		KeepAlive: viper.GetString("keepalive"),
+		Slices:    viper.GetInt("slices"),
+		Ordered:   viper.GetBool("ordered"),
+		OnPartial: viper.GetString("on-partial"),
+		Warnings:  os.Stderr,
+	})
 }
 
-var ConfigFile string
-var UseSSL bool
-var ValidateSSL bool
-var Host string
-var Port int
-var User string
-var Password string
-var LogLevel int
-var LogFile string
-var Proxy string
-var ProxyIsSocks bool
-var Timeout uint
 var Query string
 var QueryFile string
 var Toml bool
 var Data []string
+var DataFiles []string
+var Library string
+var TemplateId string
+var Mustache bool
+var RenderOnly bool
 var Endpoint string
+var Method string
 var JsonOutputFile string
-var SingleValue string
+var SingleValue []string
 var Aggregation string
 var ValueOnly bool
+var All bool
+var KeepAlive string
+var Slices int
+var Ordered bool
+var Output string
+var Columns []string
+var NoHeader bool
+var Flatten bool
+var OnPartial string
+var From string
+var To string
+var Last string
+var TimeField string
+var TimeZone string
+var KQL string
+var Lucene string
+var Size int
+var Sort []string
+var Fields []string
+var WatchInterval string
+var WatchMode string
 
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(ExitError)
 	}
 }
 
 func init() {
-	pwd, _ := os.Getwd()
-	cfgpath := pwd + string(os.PathSeparator) + "gobana.yml"
-	rootCmd.PersistentFlags().StringVarP(&ConfigFile, "config", "c", cfgpath, "Configuration file")
-	rootCmd.PersistentFlags().BoolVarP(&UseSSL, "ssl", "s", false, "Use SSL")
-	rootCmd.PersistentFlags().BoolVarP(&ValidateSSL, "validatessl", "v", true, "Validate SSL certificate")
-	rootCmd.PersistentFlags().StringVarP(&Host, "host", "H", "localhost", "Hostname of the server")
-	rootCmd.PersistentFlags().IntVarP(&Port, "port", "P", 9200, "Network port")
-	rootCmd.PersistentFlags().StringVarP(&User, "user", "u", "", "Username for Elasticsearch")
-	rootCmd.PersistentFlags().StringVarP(&Password, "password", "p", "", "Password for the Elasticsearch user")
-	rootCmd.PersistentFlags().IntVarP(&LogLevel, "loglevel", "l", 5, "Log level")
-	rootCmd.PersistentFlags().StringVarP(&LogFile, "logfile", "L", "", "Log file (defaults to stdout)")
-	rootCmd.PersistentFlags().StringVarP(&Proxy, "proxy", "y", "", "Proxy (defaults to none)")
-	rootCmd.PersistentFlags().BoolVarP(&ProxyIsSocks, "socks", "Y", false, "This is a SOCKS proxy")
-	rootCmd.PersistentFlags().UintVarP(&Timeout, "timeout", "T", 60, "timeout in seconds (defaults to 60)")
+	config.AddFlags(rootCmd, "gobana")
+	rootCmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
+		connectionFlags[flag.Name] = true
+	})
 	rootCmd.PersistentFlags().StringVarP(&Query, "query", "q", "", "Query to pass along")
 	rootCmd.PersistentFlags().StringVarP(&QueryFile, "queryfile", "Q", "", "File containing a query")
-	rootCmd.PersistentFlags().BoolVarP(&Toml, "toml", "t", false, "Use TOML template parsing on query file")
-	rootCmd.PersistentFlags().StringSliceVarP(&Data, "data", "d", []string{}, "Data to pass to template parsing, use key=value.")
+	rootCmd.PersistentFlags().BoolVarP(&Toml, "toml", "t", false, "Render the query as Go template")
+	rootCmd.PersistentFlags().StringArrayVarP(&Data, "data", "d", []string{}, "Data to pass to template parsing, use key=value. Values may contain commas and quotes. This flag can be used multiple times")
+	rootCmd.PersistentFlags().StringSliceVarP(&DataFiles, "datafile", "D", []string{}, "YAML, JSON or TOML files with data for template parsing, implies --toml")
+	rootCmd.PersistentFlags().StringVar(&Library, "library", "", "Directory with saved queries (*.yml) and templates to use as partials in template parsing")
+	rootCmd.PersistentFlags().StringVar(&TemplateId, "template-id", "", "Run the stored search template with the data as params")
+	rootCmd.PersistentFlags().BoolVar(&Mustache, "mustache", false, "Run the query or queryfile as inline Mustache search template with the data as params")
+	rootCmd.PersistentFlags().BoolVar(&RenderOnly, "render-only", false, "Output the query rendered from the search template instead of running it")
 	rootCmd.PersistentFlags().StringVarP(&Endpoint, "endpoint", "e", "_search", "API endpoint")
+	rootCmd.PersistentFlags().StringVarP(&Method, "method", "X", "POST", "HTTP method for the endpoint ("+strings.Join(handler.Methods, ", ")+"), GET with a query is sent as POST")
 	rootCmd.PersistentFlags().StringVarP(&JsonOutputFile, "jsonoutput", "J", "", "Output the result json into this file")
-	rootCmd.PersistentFlags().StringVarP(&SingleValue, "singlevalue", "S", "", "Output one single result value from the hits")
+	rootCmd.PersistentFlags().StringSliceVarP(&SingleValue, "singlevalue", "S", []string{}, "Output values from the hits, use dotted paths like host.name, tags[0] or items[*].id and metadata fields like _id, _index or _score. This flag can be used multiple times")
 	rootCmd.PersistentFlags().StringVarP(&Aggregation, "aggregation", "A", "", "Output one aggregation value")
 	rootCmd.PersistentFlags().BoolVarP(&ValueOnly, "valueonly", "V", false, "Output only the value")
+	rootCmd.PersistentFlags().BoolVarP(&All, "all", "a", false, "Fetch all matching hits page by page")
+	rootCmd.PersistentFlags().StringVar(&KeepAlive, "keepalive", handler.DefaultKeepAlive, "Keep alive for the point in time or scroll context when fetching all hits")
+	rootCmd.PersistentFlags().IntVar(&Slices, "slices", 1, "Number of slices to fetch in parallel when fetching all hits")
+	rootCmd.PersistentFlags().BoolVar(&Ordered, "ordered", false, "Output sliced results slice by slice instead of as they arrive")
+	rootCmd.PersistentFlags().StringVarP(&Output, "output", "o", "", "Output format for the hits ("+strings.Join(handler.OutputFormats, ", ")+")")
+	rootCmd.PersistentFlags().StringSliceVar(&Columns, "columns", []string{}, "Columns for the output format, defaults to the single value fields or the whole _source. Without columns, csv and tsv take the columns from the first hit, table from all hits")
+	rootCmd.PersistentFlags().BoolVar(&NoHeader, "noheader", false, "Omit the header row of csv, tsv and table output")
+	rootCmd.PersistentFlags().StringVar(&OnPartial, "on-partial", handler.PartialWarn, "What to do if shards failed or the search timed out ("+strings.Join(handler.PartialPolicies, ", ")+")")
+	rootCmd.PersistentFlags().BoolVarP(&Flatten, "flatten", "F", false, "Output nested bucket aggregations as one row per leaf bucket, all aggregations if --aggregation is not set")
+	rootCmd.PersistentFlags().StringVarP(&KQL, "kql", "K", "", "Query in the Kibana query language, e.g. 'status:404 and not method:GET'")
+	rootCmd.PersistentFlags().StringVar(&Lucene, "lucene", "", "Query in the Lucene query syntax, sent as query_string query")
+	rootCmd.PersistentFlags().IntVar(&Size, "size", -1, "Number of hits to return, replaces the size of the query")
+	rootCmd.PersistentFlags().StringSliceVar(&Sort, "sort", []string{}, "Sort the hits by fields like @timestamp:desc, replaces the sort of the query")
+	rootCmd.PersistentFlags().StringSliceVar(&Fields, "fields", []string{}, "Fields of the _source to return, wildcards like host.* are allowed")
+	rootCmd.PersistentFlags().StringVar(&From, "from", "", "Start of the time range, date math like now-30d/d or an RFC3339 time")
+	rootCmd.PersistentFlags().StringVar(&To, "to", "", "End of the time range, date math like now/d or an RFC3339 time")
+	rootCmd.PersistentFlags().StringVar(&Last, "last", "", "Time range back from now, e.g. 15m, 24h or 7d")
+	rootCmd.PersistentFlags().StringVar(&TimeField, "time-field", query.DefaultTimeField, "Date field of the time range")
+	rootCmd.PersistentFlags().StringVar(&TimeZone, "timezone", "", "Time zone for times without zone and rounding, e.g. Europe/Berlin, +01:00 or local")
+	rootCmd.PersistentFlags().StringVar(&WatchInterval, "watch", "", "Run the query again every interval, e.g. 30s or 5m, until interrupted")
+	rootCmd.PersistentFlags().StringVar(&WatchMode, "watch-mode", handler.WatchRedraw, "Output of --watch ("+strings.Join(handler.WatchModes, ", ")+")")
 
-	viper.SetDefault("ssl", false)
-	viper.SetDefault("validatessl", true)
-	viper.SetDefault("host", "localhost")
-	viper.SetDefault("port", 9200)
-	viper.SetDefault("user", "")
-	viper.SetDefault("password", "")
-	viper.SetDefault("loglevel", 5)
-	viper.SetDefault("logfile", "")
-	viper.SetDefault("proxy", "")
-	viper.SetDefault("socks", false)
-	viper.SetDefault("timeout", 60)
 	viper.SetDefault("query", "")
 	viper.SetDefault("queryfile", "")
 	viper.SetDefault("toml", false)
 	viper.SetDefault("data", []string{})
+	viper.SetDefault("datafile", []string{})
+	viper.SetDefault("library", "")
+	viper.SetDefault("template-id", "")
+	viper.SetDefault("mustache", false)
+	viper.SetDefault("render-only", false)
 	viper.SetDefault("endpoint", "_search")
+	viper.SetDefault("method", "POST")
 	viper.SetDefault("jsonoutput", "")
-	viper.SetDefault("singlevalue", "")
+	viper.SetDefault("singlevalue", []string{})
 	viper.SetDefault("aggregation", "")
 	viper.SetDefault("valueonly", false)
+	viper.SetDefault("all", false)
+	viper.SetDefault("keepalive", handler.DefaultKeepAlive)
+	viper.SetDefault("slices", 1)
+	viper.SetDefault("ordered", false)
+	viper.SetDefault("output", "")
+	viper.SetDefault("columns", []string{})
+	viper.SetDefault("noheader", false)
+	viper.SetDefault("flatten", false)
+	viper.SetDefault("on-partial", handler.PartialWarn)
+	viper.SetDefault("kql", "")
+	viper.SetDefault("lucene", "")
+	viper.SetDefault("size", -1)
+	viper.SetDefault("sort", []string{})
+	viper.SetDefault("fields", []string{})
+	viper.SetDefault("from", "")
+	viper.SetDefault("to", "")
+	viper.SetDefault("last", "")
+	viper.SetDefault("time-field", query.DefaultTimeField)
+	viper.SetDefault("timezone", "")
+	viper.SetDefault("watch", "")
+	viper.SetDefault("watch-mode", handler.WatchRedraw)
 
-	viper.BindPFlag("ssl", rootCmd.PersistentFlags().Lookup("ssl"))
-	viper.BindPFlag("validatessl", rootCmd.PersistentFlags().Lookup("validatessl"))
-	viper.BindPFlag("host", rootCmd.PersistentFlags().Lookup("host"))
-	viper.BindPFlag("port", rootCmd.PersistentFlags().Lookup("port"))
-	viper.BindPFlag("user", rootCmd.PersistentFlags().Lookup("user"))
-	viper.BindPFlag("password", rootCmd.PersistentFlags().Lookup("password"))
-	viper.BindPFlag("loglevel", rootCmd.PersistentFlags().Lookup("loglevel"))
-	viper.BindPFlag("logfile", rootCmd.PersistentFlags().Lookup("logfile"))
-	viper.BindPFlag("proxy", rootCmd.PersistentFlags().Lookup("proxy"))
-	viper.BindPFlag("socks", rootCmd.PersistentFlags().Lookup("socks"))
-	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
 	viper.BindPFlag("query", rootCmd.PersistentFlags().Lookup("query"))
 	viper.BindPFlag("queryfile", rootCmd.PersistentFlags().Lookup("queryfile"))
 	viper.BindPFlag("toml", rootCmd.PersistentFlags().Lookup("toml"))
 	viper.BindPFlag("data", rootCmd.PersistentFlags().Lookup("data"))
+	viper.BindPFlag("datafile", rootCmd.PersistentFlags().Lookup("datafile"))
+	viper.BindPFlag("library", rootCmd.PersistentFlags().Lookup("library"))
+	viper.BindPFlag("template-id", rootCmd.PersistentFlags().Lookup("template-id"))
+	viper.BindPFlag("mustache", rootCmd.PersistentFlags().Lookup("mustache"))
+	viper.BindPFlag("render-only", rootCmd.PersistentFlags().Lookup("render-only"))
 	viper.BindPFlag("endpoint", rootCmd.PersistentFlags().Lookup("endpoint"))
+	viper.BindPFlag("method", rootCmd.PersistentFlags().Lookup("method"))
 	viper.BindPFlag("jsonoutput", rootCmd.PersistentFlags().Lookup("jsonoutput"))
 	viper.BindPFlag("singlevalue", rootCmd.PersistentFlags().Lookup("singlevalue"))
 	viper.BindPFlag("aggregation", rootCmd.PersistentFlags().Lookup("aggregation"))
 	viper.BindPFlag("valueonly", rootCmd.PersistentFlags().Lookup("valueonly"))
+	viper.BindPFlag("all", rootCmd.PersistentFlags().Lookup("all"))
+	viper.BindPFlag("keepalive", rootCmd.PersistentFlags().Lookup("keepalive"))
+	viper.BindPFlag("slices", rootCmd.PersistentFlags().Lookup("slices"))
+	viper.BindPFlag("ordered", rootCmd.PersistentFlags().Lookup("ordered"))
+	viper.BindPFlag("output", rootCmd.PersistentFlags().Lookup("output"))
+	viper.BindPFlag("columns", rootCmd.PersistentFlags().Lookup("columns"))
+	viper.BindPFlag("noheader", rootCmd.PersistentFlags().Lookup("noheader"))
+	viper.BindPFlag("flatten", rootCmd.PersistentFlags().Lookup("flatten"))
+	viper.BindPFlag("on-partial", rootCmd.PersistentFlags().Lookup("on-partial"))
+	viper.BindPFlag("kql", rootCmd.PersistentFlags().Lookup("kql"))
+	viper.BindPFlag("lucene", rootCmd.PersistentFlags().Lookup("lucene"))
+	viper.BindPFlag("size", rootCmd.PersistentFlags().Lookup("size"))
+	viper.BindPFlag("sort", rootCmd.PersistentFlags().Lookup("sort"))
+	viper.BindPFlag("fields", rootCmd.PersistentFlags().Lookup("fields"))
+	viper.BindPFlag("from", rootCmd.PersistentFlags().Lookup("from"))
+	viper.BindPFlag("to", rootCmd.PersistentFlags().Lookup("to"))
+	viper.BindPFlag("last", rootCmd.PersistentFlags().Lookup("last"))
+	viper.BindPFlag("time-field", rootCmd.PersistentFlags().Lookup("time-field"))
+	viper.BindPFlag("timezone", rootCmd.PersistentFlags().Lookup("timezone"))
+	viper.BindPFlag("watch", rootCmd.PersistentFlags().Lookup("watch"))
+	viper.BindPFlag("watch-mode", rootCmd.PersistentFlags().Lookup("watch-mode"))
 }
 
-func HandleConfigFile() error {
-	if ConfigFile != "" {
-		log.Debug("Read config from " + ConfigFile)
-		viper.SetConfigFile(ConfigFile)
-	} else {
-		log.Debug("Read config from home directory")
-		home, err := homedir.Dir()
-		if err != nil {
-			log.Error(err)
-			return err
-		}
-		viper.AddConfigPath(home)
-		ex, err := os.Executable()
+// executeAll streams all pages of the query to the outputs, followed by
+// the aggregations of the complete result set. Cancelling ctx (Ctrl-C)
+// stops paging, the point in time or scroll context is cleared in any case.
+func executeAll(ctx context.Context, g *handler.Gobana) error {
+	var jsonOutput *os.File
+	var err error
+
+	logger := log.WithField("func", "executeAll")
+	jsonFile := viper.GetString("jsonoutput")
+	if jsonFile != "" {
+		jsonOutput, err = os.Create(jsonFile)
 		if err != nil {
-			log.Error(err)
+			logger.Error(err)
 			return err
 		}
-		pwd := filepath.Dir(ex)
-		viper.AddConfigPath(pwd)
-		viper.SetConfigName("gobana")
+		defer jsonOutput.Close()
 	}
-	if err := viper.ReadInConfig(); err != nil {
-		log.Error("Can't read config: " + err.Error())
+	output, err := newFormatter(os.Stdout, true)
+	if err != nil {
 		return err
 	}
+	columns := outputColumns()
+	fieldNames := viper.GetStringSlice("singlevalue")
+	valueOnly := viper.GetBool("valueonly")
 
-	return nil
+	err = g.ExecuteAll(ctx, func(page *handler.ElasticsearchResult) error {
+		if jsonOutput != nil {
+			if err := page.WriteJson(jsonOutput); err != nil {
+				return err
+			}
+		}
+		if output != nil {
+			if err := page.WriteRecords(output, columns); err != nil {
+				return err
+			}
+		} else if len(fieldNames) > 0 {
+			if err := page.SingleValue(os.Stdout, fieldNames, valueOnly); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if output != nil {
+		if cerr := output.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	aggregations, err := g.ExecuteAggregations(ctx, viper.GetString("aggregation"))
+	if err != nil || aggregations == nil {
+		return err
+	}
+	return writeAggregation(os.Stdout, aggregations)
 }
 
-func InitLogging() error {
-	LogFile = viper.GetString("logfile")
-	LogLevel = viper.GetInt("loglevel")
-	if LogFile == "" {
-		log.SetOutput(os.Stdout)
-	} else {
-		f, err := os.Create(LogFile)
+// writeAggregation writes the aggregation selected by --aggregation to
+// Writer. With --flatten, nested bucket aggregations are written as rows in
+// the output format, which defaults to a table.
+func writeAggregation(Writer io.Writer, result *handler.ElasticsearchResult) error {
+	name := viper.GetString("aggregation")
+	if !viper.GetBool("flatten") {
+		if name != "" {
+			return result.GetAggregation(Writer, name, viper.GetBool("valueonly"))
+		}
+		return nil
+	}
+	var names []string
+	if name != "" {
+		names = []string{name}
+	}
+	format := viper.GetString("output")
+	if format == "" {
+		format = handler.OutputTable
+	}
+	output, err := handler.NewFormatter(format, Writer, viper.GetStringSlice("columns"), !viper.GetBool("noheader"))
+	if err != nil {
+		return err
+	}
+	for _, record := range result.FlattenAggregations(names) {
+		err = output.WriteRecord(record)
 		if err != nil {
-			fmt.Println("Could not create logfile '" + LogFile + "'")
+			log.WithField("func", "writeAggregation").Error(err)
 			return err
 		}
-		w := bufio.NewWriter(f)
-		log.SetOutput(w)
-	}
-	switch LogLevel {
-	case 0:
-		log.SetLevel(log.PanicLevel)
-	case 1:
-		log.SetLevel(log.FatalLevel)
-	case 2:
-		log.SetLevel(log.ErrorLevel)
-	case 3:
-		log.SetLevel(log.WarnLevel)
-	case 4:
-		log.SetLevel(log.InfoLevel)
-	case 5:
-		log.SetLevel(log.DebugLevel)
-	default:
-		log.SetLevel(log.DebugLevel)
-	}
-	log.WithFields(log.Fields{
-		"LogFile":  LogFile,
-		"LogLevel": LogLevel,
-	}).Debug("Logging configured")
-	log.Debug("PersistentPreRun finished")
-	return nil
+	}
+	return output.Close()
+}
+
+// newFormatter returns the formatter selected by --output writing to
+// Writer or nil, if the hits are written the classic way. Header is false
+// to omit the header row in addition to --noheader.
+func newFormatter(Writer io.Writer, Header bool) (handler.Formatter, error) {
+	format := viper.GetString("output")
+	if format == "" {
+		return nil, nil
+	}
+	return handler.NewFormatter(format, Writer, outputColumns(), Header && !viper.GetBool("noheader"))
+}
+
+// outputColumns returns the columns for formatted output. They default to
+// the single value fields.
+func outputColumns() []string {
+	columns := viper.GetStringSlice("columns")
+	if len(columns) == 0 {
+		columns = viper.GetStringSlice("singlevalue")
+	}
+	return columns
 }
@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestDataFlag(t *testing.T) {
+	err := rootCmd.ParseFlags([]string{"-d", "Hosts=[web1,web2]", "-d", `Id="10"`, "--data", "Name=a, b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Hosts=[web1,web2]", `Id="10"`, "Name=a, b"}
+	if got := viper.GetStringSlice("data"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("data = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/joernott/elasticsearch-tools/gobana/handler"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var TailLines int
+var TailInterval string
+var Tiebreaker string
+var LineFormat string
+
+var tailCmd = &cobra.Command{
+	Use:   "tail INDEX",
+	Short: "Follow the newest documents of an index pattern",
+	Long: `Show the last documents of the index pattern sorted by --time-field and poll for
+newer ones like tail -f, until interrupted. The documents can be filtered with --query,
+--queryfile, --kql or --lucene and are written as one line of json or with the line
+template --format, e.g. '{{ field "@timestamp" . }} {{ field "host.name" . }} {{ field "message" . }}'.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd, "query", "queryfile", "toml", "data", "datafile", "library", "kql", "lucene", "time-field")
+		interval, err := time.ParseDuration(viper.GetString("tail-interval"))
+		if err != nil || interval <= 0 {
+			fmt.Fprintln(os.Stderr, "Invalid interval '"+viper.GetString("tail-interval")+"', use e.g. 2s or 1m")
+			os.Exit(ExitSetup)
+		}
+		var line *template.Template
+		if format := viper.GetString("tail-format"); format != "" {
+			line, err = handler.NewLineTemplate(format)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Invalid line template:", err)
+				os.Exit(ExitSetup)
+			}
+		}
+		g, err := newGobana(connect(), args[0]+"/_search")
+		if err != nil {
+			os.Exit(ExitSetup)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		var outputError error
+		reported := false
+		err = g.Tail(ctx, handler.TailOptions{
+			TimeField:  viper.GetString("time-field"),
+			Tiebreaker: viper.GetString("tail-tiebreaker"),
+			Lines:      viper.GetInt("tail-lines"),
+			Interval:   interval,
+			OnError: func(Err error, Delay time.Duration) bool {
+				if outputError != nil {
+					fmt.Fprintln(os.Stderr, outputError)
+					return false
+				}
+				reported = !retryError(Err, Delay)
+				return !reported
+			},
+		}, func(page *handler.ElasticsearchResult) error {
+			outputError = page.WriteLines(os.Stdout, line)
+			return outputError
+		})
+		if outputError != nil {
+			os.Exit(ExitOutput)
+		}
+		if reported {
+			os.Exit(exitCode(err))
+		}
+		if err != nil {
+			stop()
+			exitOnQueryError(err)
+		}
+	},
+}
+
+func init() {
+	tailCmd.Flags().IntVarP(&TailLines, "lines", "n", handler.DefaultTailLines, "Number of documents to show first")
+	tailCmd.Flags().StringVar(&TailInterval, "interval", handler.DefaultTailInterval.String(), "Time between polls for new documents")
+	tailCmd.Flags().StringVar(&Tiebreaker, "tiebreaker", "", "Field with a unique value per document to sort documents with the same timestamp, e.g. event.sequence")
+	tailCmd.Flags().StringVarP(&LineFormat, "format", "f", "", "Go template for a line, defaults to the _source as json")
+	viper.SetDefault("tail-lines", handler.DefaultTailLines)
+	viper.SetDefault("tail-interval", handler.DefaultTailInterval.String())
+	viper.SetDefault("tail-tiebreaker", "")
+	viper.SetDefault("tail-format", "")
+	viper.BindPFlag("tail-lines", tailCmd.Flags().Lookup("lines"))
+	viper.BindPFlag("tail-interval", tailCmd.Flags().Lookup("interval"))
+	viper.BindPFlag("tail-tiebreaker", tailCmd.Flags().Lookup("tiebreaker"))
+	viper.BindPFlag("tail-format", tailCmd.Flags().Lookup("format"))
+	rootCmd.AddCommand(tailCmd)
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/joernott/elasticsearch-tools/gobana/handler"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var TemplateIdOverride string
+
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Manage stored search templates",
+	Long:  `List, show, store and delete the Mustache search templates stored in the cluster`,
+}
+
+var templatesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the stored search templates",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd, recordFlags...)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		records, err := handler.StoredTemplates(ctx, connect())
+		if err != nil {
+			stop()
+			exitOnQueryError(err)
+		}
+		if len(viper.GetStringSlice("columns")) == 0 && viper.GetString("output") == "" {
+			viper.Set("columns", []string{"id"})
+		}
+		writeRecords(records)
+	},
+}
+
+var templatesShowCmd = &cobra.Command{
+	Use:   "show ID",
+	Short: "Show the source of a stored search template",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		source, err := handler.GetTemplate(ctx, connect(), args[0])
+		if err != nil {
+			stop()
+			exitOnQueryError(err)
+		}
+		printBody([]byte(source))
+	},
+}
+
+var templatesPutCmd = &cobra.Command{
+	Use:   "put FILE...",
+	Short: "Store search templates from files",
+	Long: `Store the Mustache search templates from the files, using the file names without
+extension as ids. - reads a template from stdin, which needs --id. Existing templates
+are replaced.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd)
+		id := viper.GetString("templates-id")
+		if id != "" && len(args) > 1 {
+			fmt.Fprintln(os.Stderr, "--id can only be used with one file")
+			os.Exit(ExitSetup)
+		}
+		connection := connect()
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		for _, file := range args {
+			templateId := id
+			if templateId == "" {
+				templateId = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+			}
+			if templateId == "-" {
+				fmt.Fprintln(os.Stderr, "Reading a template from stdin needs --id")
+				os.Exit(ExitSetup)
+			}
+			if file != "-" {
+				file = "@" + file
+			}
+			source, err := readBody(file)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(ExitSetup)
+			}
+			err = handler.PutTemplate(ctx, connection, templateId, string(source))
+			if err != nil {
+				stop()
+				exitOnQueryError(err)
+			}
+			fmt.Println("Stored template '" + templateId + "'")
+		}
+	},
+}
+
+var templatesDeleteCmd = &cobra.Command{
+	Use:   "delete ID...",
+	Short: "Delete stored search templates",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectFlags(cmd)
+		connection := connect()
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		for _, id := range args {
+			err := handler.DeleteTemplate(ctx, connection, id)
+			if err != nil {
+				stop()
+				exitOnQueryError(err)
+			}
+			fmt.Println("Deleted template '" + id + "'")
+		}
+	},
+}
+
+func init() {
+	templatesPutCmd.Flags().StringVar(&TemplateIdOverride, "id", "", "Id of the template, defaults to the file name without extension")
+	viper.SetDefault("templates-id", "")
+	viper.BindPFlag("templates-id", templatesPutCmd.Flags().Lookup("id"))
+	templatesCmd.AddCommand(templatesListCmd)
+	templatesCmd.AddCommand(templatesShowCmd)
+	templatesCmd.AddCommand(templatesPutCmd)
+	templatesCmd.AddCommand(templatesDeleteCmd)
+	rootCmd.AddCommand(templatesCmd)
+}
@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/joernott/elasticsearch-tools/gobana/handler"
+	"github.com/joernott/lra"
+	"github.com/spf13/viper"
+)
+
+// Escape sequence moving the cursor home and clearing the terminal.
+const clearScreen = "\x1b[H\x1b[2J"
+
+// watchQuery runs the query every --watch interval until ctx is cancelled.
+// Every run creates the query again, so templates using now are rendered
+// again, and reuses the Connection. Failed runs are reported on stderr and
+// retried with a growing delay, except query parse and authentication
+// errors.
+func watchQuery(ctx context.Context, Connection *lra.Connection) error {
+	w := newWatcher()
+	if viper.GetBool("all") {
+		fmt.Fprintln(os.Stderr, "Can't use --all with --watch")
+		os.Exit(ExitSetup)
+	}
+	if _, err := newFormatter(&bytes.Buffer{}, true); err != nil {
+		os.Exit(ExitSetup)
+	}
+
+	return handler.Watch(ctx, handler.WatchOptions{
+		Interval: w.Interval,
+		OnError:  retryError,
+	}, func(ctx context.Context) error {
+		g, err := newGobana(Connection, viper.GetString("endpoint"))
+		if err != nil {
+			return err
+		}
+		result, err := execute(ctx, g)
+		if err != nil {
+			return err
+		}
+		if jsonFile := viper.GetString("jsonoutput"); jsonFile != "" {
+			err = writeJsonFile(jsonFile, result)
+			if err != nil {
+				return err
+			}
+		}
+		return w.write(result)
+	})
+}
+
+// watchRecords runs fetch every --watch interval like watchQuery and
+// writes the records in the format selected by --output, which defaults to
+// a table. Append mode writes the records only if they changed.
+func watchRecords(ctx context.Context, fetch func(ctx context.Context) ([]handler.Record, error)) error {
+	w := newWatcher()
+	if _, err := recordFormatter(&bytes.Buffer{}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitSetup)
+	}
+
+	return handler.Watch(ctx, handler.WatchOptions{
+		Interval: w.Interval,
+		OnError:  retryError,
+	}, func(ctx context.Context) error {
+		records, err := fetch(ctx)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		output, err := recordFormatter(&buf)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := output.WriteRecord(record); err != nil {
+				return err
+			}
+		}
+		if err := output.Close(); err != nil {
+			return err
+		}
+		now := time.Now().Format(time.DateTime)
+		if w.Mode == handler.WatchAppend {
+			w.appendChanged(now, buf.String())
+		} else {
+			w.redraw("Every "+w.Interval.String()+": "+now, buf.String())
+		}
+		return nil
+	})
+}
+
+// newWatcher returns a watcher for the --watch interval and --watch-mode
+// and exits if they are invalid.
+func newWatcher() *watcher {
+	interval, err := time.ParseDuration(viper.GetString("watch"))
+	if err != nil || interval < time.Second {
+		fmt.Fprintln(os.Stderr, "Invalid watch interval '"+viper.GetString("watch")+"', use at least 1s, e.g. 30s or 5m")
+		os.Exit(ExitSetup)
+	}
+	mode := viper.GetString("watch-mode")
+	if !slices.Contains(handler.WatchModes, mode) {
+		fmt.Fprintln(os.Stderr, "Unknown watch mode '"+mode+"', use one of", handler.WatchModes)
+		os.Exit(ExitSetup)
+	}
+	return &watcher{
+		Interval:  interval,
+		Mode:      mode,
+		Highlight: isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "",
+	}
+}
+
+// retryError reports the error of a failed run on stderr. It returns false
+// for query parse and authentication errors, which won't go away by
+// retrying.
+func retryError(Err error, Delay time.Duration) bool {
+	fmt.Fprint(os.Stderr, time.Now().Format(time.DateTime)+" "+handler.DescribeError(Err))
+	switch handler.KindOf(Err) {
+	case handler.ErrorQueryParse, handler.ErrorAuthentication:
+		return false
+	}
+	fmt.Fprintln(os.Stderr, "Retrying in", Delay)
+	return true
+}
+
+// watcher keeps the output of the previous run to show the changes.
+type watcher struct {
+	Interval  time.Duration
+	Mode      string
+	Highlight bool
+
+	runs          int
+	count         int64
+	output        string
+	aggregation   string
+	seen          map[string]bool
+	headerWritten bool
+}
+
+// write outputs the result of a run. Redraw replaces the previous output
+// and highlights the changes, append writes only new hits and changed
+// aggregations, or the response of a non-search endpoint when it changed.
+// The number of hits and its change is shown in the header line of redraw
+// and on stderr in append mode.
+func (w *watcher) write(result *handler.ElasticsearchResult) error {
+	now := time.Now().Format(time.DateTime)
+	if w.Mode == handler.WatchAppend && isRaw(result) {
+		var buf bytes.Buffer
+		if err := writeBody(&buf, result.Body); err != nil {
+			return err
+		}
+		w.appendChanged(now, buf.String())
+		return nil
+	}
+	count := result.Hits.Total.Value
+	if result.Count != 0 {
+		count = result.Count
+	}
+	total := w.countText(count, result.Hits.Total.Relation)
+
+	if w.Mode == handler.WatchAppend {
+		hits := result.WithoutHits(w.seen)
+		hits.Aggregations = nil
+		fmt.Fprintf(os.Stderr, "%s %s, %d new\n", now, total, len(hits.Hits.Hits))
+		if len(hits.Hits.Hits) > 0 {
+			output, err := newFormatter(os.Stdout, !w.headerWritten)
+			if err != nil {
+				return err
+			}
+			w.headerWritten = output != nil
+			err = writeResult(os.Stdout, output, hits)
+			if err != nil {
+				return err
+			}
+		}
+		var buf bytes.Buffer
+		err := writeAggregation(&buf, result)
+		if err != nil {
+			return err
+		}
+		if aggregation := buf.String(); aggregation != w.aggregation {
+			fmt.Print(w.changes(w.aggregation, aggregation))
+			w.aggregation = aggregation
+		}
+		w.seen = result.HitKeys()
+	} else {
+		var buf bytes.Buffer
+		output, err := newFormatter(&buf, true)
+		if err != nil {
+			return err
+		}
+		err = writeResult(&buf, output, result)
+		if err != nil {
+			return err
+		}
+		w.count = count
+		w.redraw("Every "+w.Interval.String()+": "+now+", "+total, buf.String())
+		return nil
+	}
+	w.count = count
+	w.runs++
+	return nil
+}
+
+// appendChanged writes the Output with the Time on stderr if it changed
+// since the previous run.
+func (w *watcher) appendChanged(Time string, Output string) {
+	if w.runs == 0 || Output != w.output {
+		fmt.Fprintln(os.Stderr, Time)
+		fmt.Print(w.changes(w.output, Output))
+	}
+	w.output = Output
+	w.runs++
+}
+
+// redraw replaces the output of the previous run with the Header line and
+// the Output with the changes highlighted.
+func (w *watcher) redraw(Header string, Output string) {
+	if w.Highlight {
+		fmt.Print(clearScreen)
+	} else if w.runs > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("%s\n\n", Header)
+	fmt.Print(w.changes(w.output, Output))
+	w.output = Output
+	w.runs++
+}
+
+// changes returns Current with the changes to Previous highlighted, if
+// highlighting is enabled and there was a previous run.
+func (w *watcher) changes(Previous string, Current string) string {
+	if !w.Highlight || w.runs == 0 {
+		return Current
+	}
+	return handler.HighlightChanges(Previous, Current)
+}
+
+// countText returns the number of hits and the change since the previous
+// run, like "hits: 1234 (+12)".
+func (w *watcher) countText(Count int64, Relation string) string {
+	text := "hits: " + strconv.FormatInt(Count, 10)
+	if Relation == "gte" {
+		text = "hits: >=" + strconv.FormatInt(Count, 10)
+	}
+	if w.runs == 0 || Count == w.count {
+		return text
+	}
+	change := strconv.FormatInt(Count-w.count, 10)
+	if Count > w.count {
+		change = "+" + change
+	}
+	if w.Highlight {
+		change = handler.HighlightOn + change + handler.HighlightOff
+	}
+	return text + " (" + change + ")"
+}
+
+// isTerminal returns true if the file is a terminal.
+func isTerminal(File *os.File) bool {
+	info, err := File.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/joernott/elasticsearch-tools/gobana/handler"
+	"github.com/spf13/viper"
+)
+
+func TestWatcherAppendRaw(t *testing.T) {
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedOut, savedErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout, stderr
+	defer func() { os.Stdout, os.Stderr = savedOut, savedErr }()
+	viper.Set("endpoint", "_cluster/health")
+	defer viper.Set("endpoint", "")
+
+	w := &watcher{Mode: handler.WatchAppend}
+	for _, body := range []string{`{"status":"green"}`, `{"status":"green"}`, `{"status":"yellow"}`} {
+		if err := w.write(&handler.ElasticsearchResult{Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stdout.Seek(0, io.SeekStart)
+	got, _ := io.ReadAll(stdout)
+	want := "{\n  \"status\": \"green\"\n}\n{\n  \"status\": \"yellow\"\n}\n"
+	if string(got) != want {
+		t.Errorf("append output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,326 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bucketFields are the fields of a bucket which are no sub aggregations.
+var bucketFields = map[string]bool{
+	"key":                         true,
+	"key_as_string":               true,
+	"doc_count":                   true,
+	"from":                        true,
+	"from_as_string":              true,
+	"to":                          true,
+	"to_as_string":                true,
+	"doc_count_error_upper_bound": true,
+	"sum_other_doc_count":         true,
+	"bg_count":                    true,
+	"score":                       true,
+	"meta":                        true,
+}
+
+// FlattenAggregations walks nested bucket aggregations (terms, histogram,
+// date_histogram, range, filters, composite, ...) and returns one record
+// per leaf bucket. A record holds the keys of the buckets on the way in a
+// column named after the aggregation, their doc_count in a column
+// "name.doc_count" and the values of the metric aggregations. If no names are given, all
+// aggregations are flattened. Names may be paths like "by_host>by_day", see
+// AggregationPath.
+func (result *ElasticsearchResult) FlattenAggregations(Names []string) []Record {
+	logger := log.WithField("func", "ElasticsearchResult.FlattenAggregations")
+
+	if len(Names) == 0 {
+		for name := range result.Aggregations {
+			Names = append(Names, name)
+		}
+		sort.Strings(Names)
+	}
+	var records []Record
+	for _, name := range Names {
+		matches, err := result.AggregationPath(name)
+		if err != nil {
+			logger.WithField("aggregation", name).Warn(err)
+			continue
+		}
+		if len(matches) == 0 {
+			logger.WithField("aggregation", name).Warn("Aggregation not found")
+			continue
+		}
+		last := name[strings.LastIndex(name, ">")+1:]
+		if i := strings.Index(last, "["); i >= 0 {
+			last = last[:i]
+		}
+		for _, match := range matches {
+			row := Record{}
+			for i := range match.Names {
+				row = withField(row, match.Names[i], match.Keys[i])
+			}
+			records = append(records, flattenAggregation(strings.TrimSpace(last), match.Aggregation, row)...)
+		}
+	}
+	logger.WithField("rows", len(records)).Debug("Flattened aggregations")
+	return records
+}
+
+// flattenAggregation returns the rows of one aggregation, each starting
+// with the columns of Row.
+func flattenAggregation(Name string, Aggregation AggregationResult, Row Record) []Record {
+	if !Aggregation.IsBucket() {
+		if _, ok := Aggregation["doc_count"]; ok {
+			return flattenBucket(Name, Aggregation, Row)
+		}
+		return []Record{withMetric(Row, Name, Aggregation)}
+	}
+	var records []Record
+	for _, bucket := range Aggregation.Buckets() {
+		records = append(records, flattenBucket(Name, bucket.Raw, withBucketKey(Row, Name, bucket))...)
+	}
+	return records
+}
+
+// flattenBucket adds doc_count and metrics of a bucket of the aggregation
+// Name to the row and descends into its bucket sub aggregations. The
+// doc_count is prefixed with the name, so the counts of nested buckets
+// don't replace each other.
+func flattenBucket(Name string, Bucket map[string]interface{}, Row Record) []Record {
+	if count, ok := Bucket["doc_count"]; ok {
+		Row = withField(Row, Name+".doc_count", count)
+	}
+	var nested []string
+	for _, key := range sortedKeys(Bucket) {
+		if bucketFields[key] {
+			continue
+		}
+		sub, ok := Bucket[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if isBucketAggregation(sub) {
+			nested = append(nested, key)
+			continue
+		}
+		Row = withMetric(Row, key, sub)
+	}
+	if len(nested) == 0 {
+		return []Record{Row}
+	}
+	var records []Record
+	for _, key := range nested {
+		records = append(records, flattenAggregation(key, Bucket[key].(map[string]interface{}), Row)...)
+	}
+	if len(records) == 0 {
+		return []Record{Row}
+	}
+	return records
+}
+
+// isBucketAggregation returns true for multi and single bucket
+// aggregations.
+func isBucketAggregation(Aggregation map[string]interface{}) bool {
+	if _, ok := Aggregation["buckets"]; ok {
+		return true
+	}
+	_, ok := Aggregation["doc_count"]
+	return ok
+}
+
+// withBucketKey adds the key of a bucket to the row. Composite keys are
+// split into one column per source.
+func withBucketKey(Row Record, Name string, Bucket Bucket) Record {
+	if Bucket.KeyAsString != "" {
+		return withField(Row, Name, Bucket.KeyAsString)
+	}
+	if composite, ok := Bucket.Key.(map[string]interface{}); ok {
+		for _, source := range sortedKeys(composite) {
+			Row = withField(Row, source, composite[source])
+		}
+		return Row
+	}
+	return withField(Row, Name, Bucket.Key)
+}
+
+// withMetric adds the values of a metric aggregation to the row. Single
+// value metrics use the name of the aggregation as column, multi value
+// metrics like stats or percentiles one column per value named
+// "name.value".
+func withMetric(Row Record, Name string, Metric map[string]interface{}) Record {
+	if value, ok := Metric["value"]; ok {
+		return withField(Row, Name, value)
+	}
+	if values, ok := Metric["values"]; ok {
+		switch v := values.(type) {
+		case map[string]interface{}:
+			for _, key := range sortedKeys(v) {
+				Row = withField(Row, Name+"."+key, v[key])
+			}
+			return Row
+		case []interface{}:
+			for _, e := range v {
+				if item, ok := e.(map[string]interface{}); ok {
+					Row = withField(Row, Name+"."+FormatValue(item["key"]), item["value"])
+				}
+			}
+			return Row
+		}
+	}
+	if hits, ok := Metric["hits"].(map[string]interface{}); ok {
+		var sources []interface{}
+		if list, ok := hits["hits"].([]interface{}); ok {
+			for _, e := range list {
+				if hit, ok := e.(map[string]interface{}); ok {
+					sources = append(sources, hit["_source"])
+				}
+			}
+		}
+		return withField(Row, Name, sources)
+	}
+	for _, key := range sortedKeys(Metric) {
+		switch Metric[key].(type) {
+		case map[string]interface{}, []interface{}:
+			continue
+		}
+		if key == "meta" || strings.HasSuffix(key, "_as_string") {
+			continue
+		}
+		Row = withField(Row, Name+"."+key, Metric[key])
+	}
+	return Row
+}
+
+// withField returns a copy of the row with the field added or replaced.
+func withField(Row Record, Field string, Value interface{}) Record {
+	record := Record{
+		Fields: make([]string, 0, len(Row.Fields)+1),
+		Values: make(map[string]interface{}, len(Row.Values)+1),
+	}
+	for _, f := range Row.Fields {
+		record.Fields = append(record.Fields, f)
+		record.Values[f] = Row.Values[f]
+	}
+	if _, ok := record.Values[Field]; !ok {
+		record.Fields = append(record.Fields, Field)
+	}
+	record.Values[Field] = Value
+	return record
+}
+
+func sortedKeys[V any](Map map[string]V) []string {
+	keys := make([]string, 0, len(Map))
+	for k := range Map {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ExecuteComposite executes the query and, if the aggregation Name is a
+// composite aggregation returning an after_key, re-issues the query with
+// "after" set until all pages are fetched. The buckets of all pages are
+// collected in the aggregation of the returned result. Follow up pages
+// are requested with size 0, so the hits are those of the first page.
+func (gobana *Gobana) ExecuteComposite(ctx context.Context, Name string) (*ElasticsearchResult, error) {
+	logger := log.WithFields(log.Fields{"func": "Gobana.ExecuteComposite", "aggregation": Name})
+
+	result, err := gobana.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aggregation, ok := result.Aggregations[Name]
+	if !ok {
+		return result, nil
+	}
+	afterKey := aggregation.AfterKey()
+	if afterKey == nil {
+		return result, nil
+	}
+	buckets, _ := aggregation["buckets"].([]interface{})
+
+	body, err := queryBody(gobana.Query)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	composite := compositeSource(body, Name)
+	if composite == nil {
+		logger.Warn("Composite aggregation not found in query, returning the first page only")
+		return result, nil
+	}
+	body["size"] = 0
+	pages := 1
+	for afterKey != nil {
+		composite["after"] = afterKey
+		page, err := gobana.search(ctx, gobana.Endpoint, body)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+		next := page.Aggregations[Name]
+		more, _ := next["buckets"].([]interface{})
+		if len(more) == 0 {
+			break
+		}
+		pages++
+		buckets = append(buckets, more...)
+		afterKey = next.AfterKey()
+		logger.WithFields(log.Fields{"page": pages, "buckets": len(buckets)}).Debug("Received composite page")
+	}
+	aggregation["buckets"] = buckets
+	delete(aggregation, "after_key")
+	logger.WithFields(log.Fields{"pages": pages, "buckets": len(buckets)}).Info("Fetched all composite buckets")
+	return result, nil
+}
+
+// ExecuteAggregations executes the query with size 0 for its aggregations
+// only, fetching all pages of the composite aggregation Name like
+// ExecuteComposite. It returns nil if the query has no aggregations.
+// ExecuteAll doesn't return aggregations, as they would only cover one
+// slice and one composite page.
+func (gobana *Gobana) ExecuteAggregations(ctx context.Context, Name string) (*ElasticsearchResult, error) {
+	logger := log.WithFields(log.Fields{"func": "Gobana.ExecuteAggregations", "aggregation": Name})
+
+	body, err := queryBody(gobana.Query)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if body["aggs"] == nil && body["aggregations"] == nil {
+		return nil, nil
+	}
+	body["size"] = 0
+	for _, key := range []string{"from", "sort", "search_after", "pit", "slice"} {
+		delete(body, key)
+	}
+	query, err := json.Marshal(body)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	aggregations := *gobana
+	aggregations.Query = string(query)
+	return aggregations.ExecuteComposite(ctx, Name)
+}
+
+// compositeSource returns the "composite" definition of the top level
+// aggregation Name in the query body.
+func compositeSource(Body map[string]interface{}, Name string) map[string]interface{} {
+	for _, key := range []string{"aggs", "aggregations"} {
+		aggs, ok := Body[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		aggregation, ok := aggs[Name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if composite, ok := aggregation["composite"].(map[string]interface{}); ok {
+			return composite
+		}
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bucket is one bucket of a multi bucket aggregation (terms, histogram,
+// date_histogram, range, filters, composite, ...). Key is a map for
+// composite aggregations. Keyed buckets use the key of the map, anonymous
+// filters their position.
+type Bucket struct {
+	Key         interface{}
+	KeyAsString string
+	DocCount    int64
+	From        *float64
+	To          *float64
+	Raw         AggregationResult
+}
+
+// Stats are the values of a stats aggregation. Min, Max and Avg are 0 if
+// no document had a value.
+type Stats struct {
+	Count int64
+	Min   float64
+	Max   float64
+	Avg   float64
+	Sum   float64
+}
+
+// AggregationMatch is an aggregation found by AggregationPath. Names and
+// Keys hold the bucket aggregations on the way and the keys of the buckets
+// leading to the aggregation.
+type AggregationMatch struct {
+	Names       []string
+	Keys        []string
+	Aggregation AggregationResult
+}
+
+// Value returns the value of a single value metric aggregation (avg, sum,
+// min, max, cardinality, value_count, ...). It returns false if the
+// aggregation has no numeric value, e.g. avg over no documents.
+func (a AggregationResult) Value() (float64, bool) {
+	return number(a["value"])
+}
+
+// ValueAsString returns the formatted value of a metric aggregation, e.g.
+// of min and max on date fields.
+func (a AggregationResult) ValueAsString() string {
+	s, _ := a["value_as_string"].(string)
+	return s
+}
+
+// Values returns the numeric values of a multi value metric aggregation:
+// the percents of percentiles and percentile_ranks (keyed or not) or the
+// fields of stats and extended_stats.
+func (a AggregationResult) Values() map[string]float64 {
+	values := make(map[string]float64)
+	switch v := a["values"].(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if f, ok := number(value); ok {
+				values[key] = f
+			}
+		}
+		return values
+	case []interface{}:
+		for _, e := range v {
+			item, ok := e.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if f, ok := number(item["value"]); ok {
+				values[FormatValue(item["key"])] = f
+			}
+		}
+		return values
+	}
+	for key, value := range a {
+		if key == "value" || bucketFields[key] {
+			continue
+		}
+		if f, ok := number(value); ok {
+			values[key] = f
+		}
+	}
+	return values
+}
+
+// Percentile returns the value of the percent P of a percentiles
+// aggregation.
+func (a AggregationResult) Percentile(P float64) (float64, bool) {
+	for key, value := range a.Values() {
+		if p, err := strconv.ParseFloat(key, 64); err == nil && p == P {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// Stats returns the values of a stats or extended_stats aggregation.
+func (a AggregationResult) Stats() (Stats, bool) {
+	count, ok := number(a["count"])
+	if !ok {
+		return Stats{}, false
+	}
+	stats := Stats{Count: int64(count)}
+	stats.Min, _ = number(a["min"])
+	stats.Max, _ = number(a["max"])
+	stats.Avg, _ = number(a["avg"])
+	stats.Sum, _ = number(a["sum"])
+	return stats, true
+}
+
+// DocCount returns the doc_count of a single bucket aggregation (filter,
+// nested, global, ...).
+func (a AggregationResult) DocCount() (int64, bool) {
+	count, ok := number(a["doc_count"])
+	return int64(count), ok
+}
+
+// IsBucket returns true for multi bucket aggregations.
+func (a AggregationResult) IsBucket() bool {
+	_, ok := a["buckets"]
+	return ok
+}
+
+// Buckets returns the buckets of a multi bucket aggregation. Keyed buckets
+// are sorted by key.
+func (a AggregationResult) Buckets() []Bucket {
+	var buckets []Bucket
+	switch b := a["buckets"].(type) {
+	case []interface{}:
+		for i, e := range b {
+			raw, ok := e.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			buckets = append(buckets, newBucket(raw, strconv.Itoa(i)))
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(b) {
+			raw, ok := b[key].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			bucket := newBucket(raw, key)
+			bucket.Key = key
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
+
+// AfterKey returns the after_key of a composite aggregation, nil on the
+// last page.
+func (a AggregationResult) AfterKey() map[string]interface{} {
+	key, _ := a["after_key"].(map[string]interface{})
+	return key
+}
+
+// TopHits returns the hits of a top_hits aggregation. Use DecodeHit to
+// decode their sources.
+func (a AggregationResult) TopHits() ([]ElasticsearchHitList, error) {
+	hits, ok := a["hits"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(hits)
+	if err != nil {
+		return nil, err
+	}
+	var result ElasticsearchHitResult
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Hits, nil
+}
+
+// Aggregation returns the sub aggregation Name of a single bucket
+// aggregation.
+func (a AggregationResult) Aggregation(Name string) (AggregationResult, bool) {
+	if bucketFields[Name] {
+		return nil, false
+	}
+	sub, ok := a[Name].(map[string]interface{})
+	return sub, ok
+}
+
+// Aggregations returns all sub aggregations of a single bucket aggregation.
+func (a AggregationResult) Aggregations() map[string]AggregationResult {
+	aggregations := make(map[string]AggregationResult)
+	for key := range a {
+		if sub, ok := a.Aggregation(key); ok {
+			aggregations[key] = sub
+		}
+	}
+	return aggregations
+}
+
+func newBucket(Raw map[string]interface{}, Fallback string) Bucket {
+	bucket := Bucket{Key: Raw["key"], Raw: Raw}
+	if bucket.Key == nil {
+		bucket.Key = Fallback
+	}
+	bucket.KeyAsString, _ = Raw["key_as_string"].(string)
+	count, _ := number(Raw["doc_count"])
+	bucket.DocCount = int64(count)
+	if from, ok := number(Raw["from"]); ok {
+		bucket.From = &from
+	}
+	if to, ok := number(Raw["to"]); ok {
+		bucket.To = &to
+	}
+	return bucket
+}
+
+// Label returns key_as_string or the formatted key of the bucket.
+func (bucket Bucket) Label() string {
+	if bucket.KeyAsString != "" {
+		return bucket.KeyAsString
+	}
+	return FormatValue(bucket.Key)
+}
+
+// Aggregation returns the sub aggregation Name of the bucket.
+func (bucket Bucket) Aggregation(Name string) (AggregationResult, bool) {
+	return bucket.Raw.Aggregation(Name)
+}
+
+// Aggregations returns the sub aggregations of the bucket.
+func (bucket Bucket) Aggregations() map[string]AggregationResult {
+	return bucket.Raw.Aggregations()
+}
+
+// AggregationPath looks up aggregations by a path of names separated by
+// ">", e.g. "by_host>by_day>avg_latency". The path descends into every
+// bucket of multi bucket aggregations on the way, unless a bucket is
+// selected with its key like in "by_host[web-1]>by_day>avg_latency".
+// Buckets without the next aggregation are skipped.
+func (result *ElasticsearchResult) AggregationPath(Path string) ([]AggregationMatch, error) {
+	var elements []pathElement
+	for _, part := range strings.Split(Path, ">") {
+		element, err := parsePathElement(part)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	first, ok := result.Aggregations[elements[0].name]
+	if !ok {
+		return nil, nil
+	}
+	matches := []AggregationMatch{{Aggregation: first}}
+	for i := 1; i < len(elements); i++ {
+		var next []AggregationMatch
+		for _, match := range matches {
+			next = append(next, match.descend(elements[i-1], elements[i].name)...)
+		}
+		matches = next
+	}
+	last := elements[len(elements)-1]
+	if last.hasKey {
+		var selected []AggregationMatch
+		for _, match := range matches {
+			for _, bucket := range match.Aggregation.Buckets() {
+				if bucket.matches(last.key) {
+					selected = append(selected, match.withBucket(last.name, bucket, bucket.Raw))
+				}
+			}
+		}
+		matches = selected
+	}
+	return matches, nil
+}
+
+// pathElement is one element of an aggregation path.
+type pathElement struct {
+	name   string
+	key    string
+	hasKey bool
+}
+
+func parsePathElement(Element string) (pathElement, error) {
+	element := pathElement{name: strings.TrimSpace(Element)}
+	if i := strings.Index(element.name, "["); i >= 0 {
+		if !strings.HasSuffix(element.name, "]") {
+			return element, errors.New("Missing ']' in aggregation path element '" + Element + "'")
+		}
+		element.key = strings.Trim(element.name[i+1:len(element.name)-1], `'"`)
+		element.name = element.name[:i]
+		element.hasKey = true
+	}
+	if element.name == "" {
+		return element, errors.New("Empty name in aggregation path element '" + Element + "'")
+	}
+	return element, nil
+}
+
+// descend returns the sub aggregation Name of the match. Multi bucket
+// aggregations are descended in every (or the selected) bucket.
+func (match AggregationMatch) descend(Element pathElement, Name string) []AggregationMatch {
+	if !match.Aggregation.IsBucket() {
+		sub, ok := match.Aggregation.Aggregation(Name)
+		if !ok {
+			return nil
+		}
+		return []AggregationMatch{{Names: match.Names, Keys: match.Keys, Aggregation: sub}}
+	}
+	var matches []AggregationMatch
+	for _, bucket := range match.Aggregation.Buckets() {
+		if Element.hasKey && !bucket.matches(Element.key) {
+			continue
+		}
+		sub, ok := bucket.Aggregation(Name)
+		if !ok {
+			continue
+		}
+		matches = append(matches, match.withBucket(Element.name, bucket, sub))
+	}
+	return matches
+}
+
+func (match AggregationMatch) withBucket(Name string, Bucket Bucket, Aggregation AggregationResult) AggregationMatch {
+	return AggregationMatch{
+		Names:       append(append([]string{}, match.Names...), Name),
+		Keys:        append(append([]string{}, match.Keys...), Bucket.Label()),
+		Aggregation: Aggregation,
+	}
+}
+
+func (bucket Bucket) matches(Key string) bool {
+	return bucket.Label() == Key || FormatValue(bucket.Key) == Key
+}
+
+// aggregationLine is one labeled value of an aggregation.
+type aggregationLine struct {
+	label string
+	value interface{}
+}
+
+// lines returns the values of the aggregation as written by
+// GetAggregation: the doc_count of every bucket, the value of single value
+// metrics, the values of multi value metrics, the doc_count of single
+// bucket aggregations or all fields of other aggregations.
+func (a AggregationResult) lines() []aggregationLine {
+	var lines []aggregationLine
+	if a.IsBucket() {
+		for _, bucket := range a.Buckets() {
+			lines = append(lines, aggregationLine{bucket.Label(), bucket.DocCount})
+		}
+		return lines
+	}
+	if value, ok := a["value"]; ok {
+		return []aggregationLine{{"value", value}}
+	}
+	if values := a.Values(); len(values) > 0 {
+		for _, key := range sortedValueKeys(values) {
+			lines = append(lines, aggregationLine{key, values[key]})
+		}
+		return lines
+	}
+	if count, ok := a.DocCount(); ok {
+		return []aggregationLine{{"doc_count", count}}
+	}
+	for _, key := range sortedKeys(a) {
+		lines = append(lines, aggregationLine{key, a[key]})
+	}
+	return lines
+}
+
+// number converts json numbers to float64.
+func number(Value interface{}) (float64, bool) {
+	switch v := Value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// sortedValueKeys sorts numeric keys (e.g. percents) by their value and
+// other keys alphabetically.
+func sortedValueKeys(Values map[string]float64) []string {
+	keys := make([]string, 0, len(Values))
+	for key := range Values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, errA := strconv.ParseFloat(keys[i], 64)
+		b, errB := strconv.ParseFloat(keys[j], 64)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
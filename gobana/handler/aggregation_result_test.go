@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAggregationPath(t *testing.T) {
+	result := readResult(t, "es8_aggregations.json")
+	tests := []struct {
+		path string
+		keys []string
+	}{
+		{"by_host", []string{""}},
+		{"by_host>by_day>avg_latency", []string{"web-1>2024-11-04", "web-1>2024-11-05", "web-2>2024-11-04"}},
+		{"by_host[web-2]>by_day>avg_latency", []string{"web-2>2024-11-04"}},
+		{"by_host>by_day[2024-11-05]", []string{"web-1>2024-11-05"}},
+		{"errors>last_error", []string{""}},
+		{"missing>avg_latency", nil},
+		{"by_host>missing", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			matches, err := result.AggregationPath(test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, match := range matches {
+				keys = append(keys, strings.Join(match.Keys, ">"))
+			}
+			if len(keys) != len(test.keys) {
+				t.Fatalf("keys = %q, want %q", keys, test.keys)
+			}
+			for i := range keys {
+				if keys[i] != test.keys[i] {
+					t.Errorf("keys[%d] = %q, want %q", i, keys[i], test.keys[i])
+				}
+			}
+		})
+	}
+	if _, err := result.AggregationPath("by_host[web-1>by_day"); err == nil {
+		t.Error("AggregationPath with unterminated key returned no error")
+	}
+}
+
+func TestAggregationAccessors(t *testing.T) {
+	result := readResult(t, "es8_aggregations.json")
+	aggs := result.Aggregations
+
+	if p, ok := aggs["latency_percentiles"].Percentile(99); !ok || p != 310 {
+		t.Errorf("Percentile(99) = %v, %v, want 310, true", p, ok)
+	}
+	stats, ok := aggs["latency_stats"].Stats()
+	if !ok || stats.Count != 5 || stats.Sum != 506 {
+		t.Errorf("Stats() = %+v, %v", stats, ok)
+	}
+	ranges := aggs["latency_ranges"].Buckets()
+	if len(ranges) != 2 || ranges[0].Key != "fast" || ranges[0].To == nil || *ranges[0].To != 100 || ranges[0].From != nil {
+		t.Errorf("range Buckets() = %+v", ranges)
+	}
+	if after := aggs["pairs"].AfterKey(); after["host"] != "web-2" {
+		t.Errorf("AfterKey() = %v", after)
+	}
+	if count, ok := aggs["errors"].DocCount(); !ok || count != 1 {
+		t.Errorf("DocCount() = %v, %v, want 1, true", count, ok)
+	}
+	lastError, ok := aggs["errors"].Aggregation("last_error")
+	if !ok {
+		t.Fatal("Aggregation(last_error) not found")
+	}
+	hits, err := lastError.TopHits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Id != "e1" || hits[0].Source["message"] != "timeout" {
+		t.Errorf("TopHits() = %+v", hits)
+	}
+	hosts := aggs["by_host"].Buckets()
+	if len(hosts) != 2 || hosts[0].Label() != "web-1" || hosts[0].DocCount != 3 {
+		t.Fatalf("terms Buckets() = %+v", hosts)
+	}
+	days, _ := hosts[1].Aggregation("by_day")
+	latency, _ := days.Buckets()[0].Aggregation("avg_latency")
+	if _, ok := latency.Value(); ok {
+		t.Error("Value() of null value returned ok")
+	}
+}
+
+func TestGetAggregation(t *testing.T) {
+	result := readResult(t, "es8_aggregations.json")
+	tests := []struct {
+		path      string
+		valueOnly bool
+		want      string
+	}{
+		{"by_host", false, "web-1:3\nweb-2:2\n"},
+		{"by_host>by_day>avg_latency", false, "web-1>2024-11-04>value:120.5\nweb-1>2024-11-05>value:80\nweb-2>2024-11-04>value:\n"},
+		{"by_host>by_day>avg_latency", true, "120.5\n80\n\n"},
+		{"latency_percentiles", false, "5.0:40\n50.0:95\n99.0:310\n"},
+		{"latency_stats", false, "avg:101.2\ncount:5\nmax:310\nmin:40\nsum:506\n"},
+		{"missing", false, ""},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := result.GetAggregation(&buf, test.path, test.valueOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("GetAggregation(%s, %v) = %q, want %q", test.path, test.valueOnly, buf.String(), test.want)
+		}
+	}
+}
+
+func TestFlattenAggregationPath(t *testing.T) {
+	result := readResult(t, "es8_aggregations.json")
+	records := result.FlattenAggregations([]string{"by_host[web-1]>by_day"})
+	if len(records) != 2 {
+		t.Fatalf("len(records) = %d, want 2", len(records))
+	}
+	want := []string{"by_host", "by_day", "by_day.doc_count", "avg_latency"}
+	if strings.Join(records[1].Fields, ",") != strings.Join(want, ",") {
+		t.Errorf("Fields = %v, want %v", records[1].Fields, want)
+	}
+	if records[1].Values["by_host"] != "web-1" || records[1].Values["avg_latency"] != 80.0 {
+		t.Errorf("Values = %v", records[1].Values)
+	}
+}
+
+func TestFlattenAggregations(t *testing.T) {
+	result := readResult(t, "es8_aggregations.json")
+	records := result.FlattenAggregations([]string{"by_host"})
+	if len(records) != 3 {
+		t.Fatalf("len(records) = %d, want 3", len(records))
+	}
+	want := []string{"by_host", "by_host.doc_count", "by_day", "by_day.doc_count", "avg_latency"}
+	if strings.Join(records[0].Fields, ",") != strings.Join(want, ",") {
+		t.Errorf("Fields = %v, want %v", records[0].Fields, want)
+	}
+	counts := []string{"3/2", "3/1", "2/2"}
+	for i, record := range records {
+		got := FormatValue(record.Values["by_host.doc_count"]) + "/" + FormatValue(record.Values["by_day.doc_count"])
+		if got != counts[i] {
+			t.Errorf("records[%d] doc_count = %s, want %s", i, got, counts[i])
+		}
+	}
+}
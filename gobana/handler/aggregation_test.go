@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// compositeCluster serves a composite aggregation "by_host" with Buckets
+// buckets, two per page.
+type compositeCluster struct {
+	Buckets int
+
+	mu     sync.Mutex
+	bodies []map[string]interface{}
+}
+
+func (c *compositeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	var body map[string]interface{}
+	decodeNumbers(data, &body)
+	c.mu.Lock()
+	c.bodies = append(c.bodies, body)
+	c.mu.Unlock()
+
+	start := 0
+	composite := body["aggs"].(map[string]interface{})["by_host"].(map[string]interface{})["composite"].(map[string]interface{})
+	if after, ok := composite["after"].(map[string]interface{}); ok {
+		n, _ := strconv.Atoi(strings.TrimPrefix(after["host"].(string), "web"))
+		start = n + 1
+	}
+	var buckets []string
+	afterKey := ""
+	for i := start; i < c.Buckets && i < start+2; i++ {
+		key := `{"host":"web` + strconv.Itoa(i) + `"}`
+		buckets = append(buckets, `{"key":`+key+`,"doc_count":`+strconv.Itoa(i+1)+`}`)
+		afterKey = `,"after_key":` + key
+	}
+	w.Write([]byte(`{"took":1,"hits":{"total":{"value":10,"relation":"eq"},"hits":[]},"aggregations":{"by_host":{"buckets":[` + strings.Join(buckets, ",") + `]` + afterKey + `}}}`))
+}
+
+const compositeQuery = `{"size":5,"sort":["@timestamp"],"aggs":{"by_host":{"composite":{"size":2,"sources":[{"host":{"terms":{"field":"host.name"}}}]}}}}`
+
+func compositeHosts(t *testing.T, result *ElasticsearchResult) string {
+	t.Helper()
+	var hosts []string
+	for _, record := range result.FlattenAggregations([]string{"by_host"}) {
+		hosts = append(hosts, FormatValue(record.Values["host"])+":"+FormatValue(record.Values["by_host.doc_count"]))
+	}
+	return strings.Join(hosts, ",")
+}
+
+func TestExecuteComposite(t *testing.T) {
+	cluster := &compositeCluster{Buckets: 5}
+	result, err := newTestGobana(t, cluster, compositeQuery).ExecuteComposite(context.Background(), "by_host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := compositeHosts(t, result); got != "web0:1,web1:2,web2:3,web3:4,web4:5" {
+		t.Errorf("buckets = %s, want web0 to web4", got)
+	}
+	if _, ok := result.Aggregations["by_host"]["after_key"]; ok {
+		t.Error("after_key kept in the merged aggregation")
+	}
+	// The first page plus three pages with after, the last one empty.
+	if len(cluster.bodies) != 4 {
+		t.Errorf("%d requests, want 4", len(cluster.bodies))
+	}
+	if size := cluster.bodies[1]["size"].(json.Number).String(); size != "0" {
+		t.Errorf("follow up page size = %s, want 0", size)
+	}
+}
+
+func TestExecuteAggregations(t *testing.T) {
+	cluster := &compositeCluster{Buckets: 3}
+	g := newTestGobana(t, cluster, compositeQuery)
+	result, err := g.ExecuteAggregations(context.Background(), "by_host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := compositeHosts(t, result); got != "web0:1,web1:2,web2:3" {
+		t.Errorf("buckets = %s, want web0 to web2", got)
+	}
+	first := cluster.bodies[0]
+	if size := first["size"].(json.Number).String(); size != "0" {
+		t.Errorf("size = %s, want 0", size)
+	}
+	if _, ok := first["sort"]; ok {
+		t.Error("sort sent with the aggregation request")
+	}
+	if g.Query != compositeQuery {
+		t.Errorf("Query changed to %s", g.Query)
+	}
+
+	result, err = newTestGobana(t, cluster, `{"size":5}`).ExecuteAggregations(context.Background(), "")
+	if err != nil || result != nil {
+		t.Errorf("ExecuteAggregations without aggregations = %v, %v, want nil, nil", result, err)
+	}
+}
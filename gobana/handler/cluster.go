@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/joernott/lra"
+	log "github.com/sirupsen/logrus"
+)
+
+// CatColumns are the default columns of the cat APIs used by the cluster
+// subcommands of gobana.
+var CatColumns = map[string][]string{
+	"health":  {"cluster", "status", "node.total", "node.data", "shards", "pri", "relo", "init", "unassign", "active_shards_percent"},
+	"indices": {"health", "status", "index", "pri", "rep", "docs.count", "store.size"},
+	"nodes":   {"name", "ip", "node.role", "master", "heap.percent", "ram.percent", "cpu", "load_1m", "disk.used_percent"},
+	"shards":  {"index", "shard", "prirep", "state", "docs", "store", "node"},
+	"aliases": {"alias", "index", "filter", "routing.index", "routing.search", "is_write_index"},
+}
+
+// CatSort is the default sort order of the cat APIs.
+var CatSort = map[string][]string{
+	"indices": {"index"},
+	"nodes":   {"name"},
+	"shards":  {"index", "shard", "prirep"},
+	"aliases": {"alias", "index"},
+}
+
+// Cat calls a cat API like indices or nodes and returns the rows as
+// records. Pattern restricts the rows to matching indices, aliases etc.,
+// if the API supports it. Columns and Sort default to CatColumns and
+// CatSort, see the cat API documentation for the available columns.
+func Cat(ctx context.Context, Connection *lra.Connection, Api string, Pattern string, Columns []string, Sort []string) ([]Record, error) {
+	logger := log.WithFields(log.Fields{"func": "Cat", "api": Api})
+
+	if len(Columns) == 0 {
+		Columns = CatColumns[Api]
+	}
+	if len(Sort) == 0 {
+		Sort = CatSort[Api]
+	}
+	path := "/_cat/" + Api
+	if Pattern != "" {
+		path += "/" + Pattern
+	}
+	params := url.Values{"format": {"json"}}
+	if len(Columns) > 0 {
+		params.Set("h", strings.Join(Columns, ","))
+	}
+	if len(Sort) > 0 {
+		params.Set("s", strings.Join(Sort, ","))
+	}
+	response, err := Request(ctx, Connection, http.MethodGet, path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	err = json.Unmarshal(response.Body, &rows)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	records := make([]Record, 0, len(rows))
+	for _, row := range rows {
+		if len(Columns) == 0 {
+			records = append(records, NewRecord(row))
+			continue
+		}
+		records = append(records, Record{Fields: Columns, Values: row})
+	}
+	return records, nil
+}
+
+// Mapping returns the fields of the mappings of the indices matching the
+// pattern as records with the columns index, field and type. Multi-fields
+// are returned as field.name, objects without type as object.
+func Mapping(ctx context.Context, Connection *lra.Connection, Pattern string) ([]Record, error) {
+	logger := log.WithField("func", "Mapping")
+
+	var result map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if err := getJson(ctx, Connection, indexPath(Pattern)+"/_mapping", &result); err != nil {
+		return nil, err
+	}
+	var records []Record
+	for _, index := range sortedKeys(result) {
+		mappings := result[index].Mappings
+		if _, ok := mappings["properties"]; !ok && len(mappings) == 1 {
+			// Mappings of Elasticsearch 6 are grouped by type.
+			for _, typeMapping := range mappings {
+				if m, ok := typeMapping.(map[string]interface{}); ok {
+					mappings = m
+				}
+			}
+		}
+		fields := make(map[string]string)
+		mappingFields("", mappings, fields)
+		for _, field := range sortedKeys(fields) {
+			records = append(records, Record{
+				Fields: []string{"index", "field", "type"},
+				Values: map[string]interface{}{"index": index, "field": field, "type": fields[field]},
+			})
+		}
+	}
+	logger.WithField("fields", len(records)).Debug("Mapping")
+	return records, nil
+}
+
+// mappingFields collects the types of the properties and multi-fields of
+// a mapping.
+func mappingFields(Prefix string, Mapping map[string]interface{}, Fields map[string]string) {
+	for _, key := range []string{"properties", "fields"} {
+		properties, ok := Mapping[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, property := range properties {
+			p, ok := property.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			field := Prefix + name
+			fieldType, ok := p["type"].(string)
+			if !ok {
+				fieldType = "object"
+			}
+			Fields[field] = fieldType
+			mappingFields(field+".", p, Fields)
+		}
+	}
+}
+
+// IndexSettings returns the settings of the indices matching the pattern
+// as records with the columns index, setting and value.
+func IndexSettings(ctx context.Context, Connection *lra.Connection, Pattern string) ([]Record, error) {
+	var result map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err := getJson(ctx, Connection, indexPath(Pattern)+"/_settings?flat_settings=true", &result); err != nil {
+		return nil, err
+	}
+	var records []Record
+	for _, index := range sortedKeys(result) {
+		settings := result[index].Settings
+		for _, setting := range sortedKeys(settings) {
+			records = append(records, Record{
+				Fields: []string{"index", "setting", "value"},
+				Values: map[string]interface{}{"index": index, "setting": setting, "value": settings[setting]},
+			})
+		}
+	}
+	return records, nil
+}
+
+// GetDocument returns the document with the id from the index including
+// the metadata fields like _index, _id and _version.
+func GetDocument(ctx context.Context, Connection *lra.Connection, Index string, Id string) (map[string]interface{}, error) {
+	logger := log.WithFields(log.Fields{"func": "GetDocument", "index": Index, "id": Id})
+
+	var document map[string]interface{}
+	err := getJson(ctx, Connection, "/"+Index+"/_doc/"+url.PathEscape(Id), &document)
+	var requestError *RequestError
+	if errors.As(err, &requestError) && requestError.Status == http.StatusNotFound && requestError.Err == nil {
+		err = errors.New("Document '" + Id + "' not found in index '" + Index + "'")
+		logger.Error(err)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// DocumentRecords converts a document into records with the columns field
+// and value. The metadata fields come first, followed by the fields of the
+// _source as dotted paths.
+func DocumentRecords(Document map[string]interface{}) []Record {
+	var records []Record
+	add := func(field string, value interface{}) {
+		records = append(records, Record{
+			Fields: []string{"field", "value"},
+			Values: map[string]interface{}{"field": field, "value": value},
+		})
+	}
+	for _, field := range []string{"_index", "_id", "_version", "_seq_no", "_primary_term", "_routing"} {
+		if value, ok := Document[field]; ok {
+			add(field, value)
+		}
+	}
+	source := make(map[string]interface{})
+	if s, ok := Document["_source"].(map[string]interface{}); ok {
+		flattenDocument("", s, source)
+	}
+	for _, field := range sortedKeys(source) {
+		add(field, source[field])
+	}
+	return records
+}
+
+// flattenDocument adds the values of the document to Values with dotted
+// paths as keys. Lists are kept as values.
+func flattenDocument(Prefix string, Document map[string]interface{}, Values map[string]interface{}) {
+	for key, value := range Document {
+		if m, ok := value.(map[string]interface{}); ok && len(m) > 0 {
+			flattenDocument(Prefix+key+".", m, Values)
+			continue
+		}
+		Values[Prefix+key] = value
+	}
+}
+
+// getJson gets the path and decodes the json response into Result.
+func getJson(ctx context.Context, Connection *lra.Connection, Path string, Result interface{}) error {
+	logger := log.WithFields(log.Fields{"func": "getJson", "path": Path})
+	response, err := Request(ctx, Connection, http.MethodGet, Path, nil)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(response.Body, Result)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// indexPath returns the path for the index pattern, all indices if it is
+// empty.
+func indexPath(Pattern string) string {
+	if Pattern == "" {
+		return ""
+	}
+	return "/" + Pattern
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMappingFields(t *testing.T) {
+	var mapping map[string]interface{}
+	err := json.Unmarshal([]byte(`{"properties": {
+		"@timestamp": {"type": "date"},
+		"host": {"properties": {"name": {"type": "text", "fields": {"keyword": {"type": "keyword"}}}}},
+		"items": {"type": "nested", "properties": {"sku": {"type": "keyword"}}}
+	}}`), &mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]string)
+	mappingFields("", mapping, fields)
+	want := map[string]string{
+		"@timestamp":        "date",
+		"host":              "object",
+		"host.name":         "text",
+		"host.name.keyword": "keyword",
+		"items":             "nested",
+		"items.sku":         "keyword",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("mappingFields = %v, want %v", fields, want)
+	}
+}
+
+func TestDocumentRecords(t *testing.T) {
+	var document map[string]interface{}
+	err := json.Unmarshal([]byte(`{"_index": "logs", "_id": "1", "_version": 2, "found": true,
+		"_source": {"host": {"name": "web1"}, "tags": ["a", "b"], "empty": {}}}`), &document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][2]string
+	for _, record := range DocumentRecords(document) {
+		rows = append(rows, [2]string{FormatValue(record.Values["field"]), FormatValue(record.Values["value"])})
+	}
+	want := [][2]string{
+		{"_index", "logs"},
+		{"_id", "1"},
+		{"_version", "2"},
+		{"empty", "{}"},
+		{"host.name", "web1"},
+		{"tags", `["a","b"]`},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("DocumentRecords = %v, want %v", rows, want)
+	}
+}
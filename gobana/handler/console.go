@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ConsoleRequest is a request read from a file in the format of the Kibana
+// Dev Tools console. Line is the line of the request in the file.
+type ConsoleRequest struct {
+	Method string
+	Path   string
+	Body   []byte
+	Line   int
+}
+
+var consoleRequestPattern = regexp.MustCompile(`^(?i)([a-z]+)\s+(\S+)\s*$`)
+
+// ParseConsole reads requests in the format of the Kibana Dev Tools
+// console:
+//
+//	# comment
+//	GET /logs-*/_search
+//	{
+//	  "query": { "match": { "message": """a "quoted" text""" } }
+//	}
+//
+//	POST _bulk
+//	{ "index": { "_index": "test" } }
+//	{ "message": "hello" }
+//
+// Lines starting with # or // are comments. Strings in triple quotes may
+// contain quotes and line breaks. Bodies with more than one json object,
+// like the ones of _bulk and _msearch, are sent as newline delimited json.
+func ParseConsole(Reader io.Reader) ([]ConsoleRequest, error) {
+	logger := log.WithField("func", "ParseConsole")
+
+	var requests []ConsoleRequest
+	var body strings.Builder
+	inString := false
+	flush := func() error {
+		if len(requests) == 0 {
+			return nil
+		}
+		r := &requests[len(requests)-1]
+		data, err := consoleBody(body.String())
+		if err != nil {
+			return errors.New("Invalid body of request '" + r.Method + " " + r.Path + "' in line " + strconv.Itoa(r.Line) + ": " + err.Error())
+		}
+		r.Body = data
+		body.Reset()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(Reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if !inString {
+			trimmed := strings.TrimSpace(text)
+			if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") {
+				continue
+			}
+			if m := consoleRequestPattern.FindStringSubmatch(trimmed); m != nil && isMethod(m[1]) {
+				if err := flush(); err != nil {
+					logger.Error(err)
+					return nil, err
+				}
+				path := m[2]
+				if !strings.HasPrefix(path, "/") {
+					path = "/" + path
+				}
+				requests = append(requests, ConsoleRequest{Method: strings.ToUpper(m[1]), Path: path, Line: line})
+				continue
+			}
+			if len(requests) == 0 {
+				if trimmed == "" {
+					continue
+				}
+				err := errors.New("Expected a request like 'GET /_search' in line " + strconv.Itoa(line))
+				logger.Error(err)
+				return nil, err
+			}
+		}
+		inString = inString != (strings.Count(text, `"""`)%2 == 1)
+		body.WriteString(text)
+		body.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if inString {
+		err := errors.New("Missing closing \"\"\" at end of file")
+		logger.Error(err)
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return requests, nil
+}
+
+// consoleBody converts strings in triple quotes to json strings and returns
+// the body. A body with more than one json value is returned as newline
+// delimited json.
+func consoleBody(Text string) ([]byte, error) {
+	parts := strings.Split(Text, `"""`)
+	var b strings.Builder
+	for i, part := range parts {
+		if i%2 == 0 {
+			b.WriteString(part)
+			continue
+		}
+		quoted, _ := json.Marshal(part)
+		b.Write(quoted)
+	}
+	text := strings.TrimSpace(b.String())
+	if text == "" {
+		return nil, nil
+	}
+
+	var values []json.RawMessage
+	decoder := json.NewDecoder(strings.NewReader(text))
+	for {
+		var value json.RawMessage
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if len(values) == 1 {
+		return []byte(text), nil
+	}
+	var ndjson bytes.Buffer
+	for _, value := range values {
+		if err := json.Compact(&ndjson, value); err != nil {
+			return nil, err
+		}
+		ndjson.WriteString("\n")
+	}
+	return ndjson.Bytes(), nil
+}
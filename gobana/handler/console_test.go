@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+const consoleFile = `# Kibana Dev Tools
+GET logs-*/_search
+{
+  "query": { "match": { "message": """a "quoted"
+text""" } }
+}
+
+// bulk request
+POST /_bulk?refresh=true
+{ "index": { "_index": "test" } }
+{ "message": "hello" }
+
+head /test
+DELETE /test
+`
+
+func TestParseConsole(t *testing.T) {
+	requests, err := ParseConsole(strings.NewReader(consoleFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ConsoleRequest{
+		{"GET", "/logs-*/_search", []byte(`{
+  "query": { "match": { "message": "a \"quoted\"\ntext" } }
+}`), 2},
+		{"POST", "/_bulk?refresh=true", []byte("{\"index\":{\"_index\":\"test\"}}\n{\"message\":\"hello\"}\n"), 9},
+		{"HEAD", "/test", nil, 13},
+		{"DELETE", "/test", nil, 14},
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(requests), len(want))
+	}
+	for i, request := range requests {
+		w := want[i]
+		if request.Method != w.Method || request.Path != w.Path || request.Line != w.Line {
+			t.Errorf("request %d = %s %s in line %d, want %s %s in line %d", i, request.Method, request.Path, request.Line, w.Method, w.Path, w.Line)
+		}
+		if string(request.Body) != string(w.Body) {
+			t.Errorf("body of request %d = %q, want %q", i, request.Body, w.Body)
+		}
+	}
+}
+
+func TestParseConsoleErrors(t *testing.T) {
+	tests := map[string]string{
+		"no request":     "{}\n",
+		"invalid body":   "GET /_search\n{ \"query\": \n",
+		"open string":    "GET /_search\n{ \"a\": \"\"\"text }\n",
+		"unknown method": "PATCH /_search\n",
+	}
+	for name, text := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseConsole(strings.NewReader(text)); err == nil {
+				t.Errorf("ParseConsole(%q) returned no error", text)
+			}
+		})
+	}
+}
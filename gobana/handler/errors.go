@@ -0,0 +1,274 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrorKind classifies errors, so callers can react on them (e.g. by
+// choosing an exit code).
+type ErrorKind int
+
+const (
+	ErrorUnknown ErrorKind = iota
+	ErrorConnection
+	ErrorAuthentication
+	ErrorQueryParse
+	ErrorIndexNotFound
+	ErrorPartialShards
+	ErrorTimeout
+)
+
+func (kind ErrorKind) String() string {
+	switch kind {
+	case ErrorConnection:
+		return "connection failure"
+	case ErrorAuthentication:
+		return "authentication failure"
+	case ErrorQueryParse:
+		return "query parse error"
+	case ErrorIndexNotFound:
+		return "index not found"
+	case ErrorPartialShards:
+		return "partial shard failure"
+	case ErrorTimeout:
+		return "timeout"
+	}
+	return "error"
+}
+
+// ElasticsearchShardFailure describes the failure of one shard.
+type ElasticsearchShardFailure struct {
+	Shard  int                `json:"shard"`
+	Index  string             `json:"index,omitempty"`
+	Node   string             `json:"node,omitempty"`
+	Reason ElasticsearchError `json:"reason"`
+}
+
+// RequestError is returned if a request fails. It holds the HTTP status
+// and the error returned by Elasticsearch, if there is one, and the error
+// of the underlying connection.
+type RequestError struct {
+	Status int
+	Err    *ElasticsearchError
+	Cause  error
+}
+
+var parseErrors = map[string]bool{
+	"parsing_exception":                true,
+	"x_content_parse_exception":        true,
+	"json_parse_exception":             true,
+	"query_parsing_exception":          true,
+	"query_shard_exception":            true,
+	"search_parse_exception":           true,
+	"illegal_argument_exception":       true,
+	"named_object_not_found_exception": true,
+}
+
+var timeoutErrors = map[string]bool{
+	"timeout_exception":                       true,
+	"elasticsearch_timeout_exception":         true,
+	"receive_timeout_transport_exception":     true,
+	"process_cluster_event_timeout_exception": true,
+}
+
+// NewRequestError creates a RequestError from the response body and the
+// error of a failed request. The status is taken from the body, it is 0
+// (unknown) if the body contains none. The error message of the connection
+// is not searched for a status, it may contain any number, e.g. of an
+// address or certificate.
+func NewRequestError(Body []byte, Err error) *RequestError {
+	e := &RequestError{Cause: Err}
+	var result ElasticsearchResult
+	if len(Body) > 0 && json.Unmarshal(Body, &result) == nil {
+		e.Status = result.Status
+		e.Err = result.Error
+	}
+	return e
+}
+
+func (e *RequestError) Error() string {
+	var parts []string
+	if e.Status != 0 {
+		parts = append(parts, "HTTP "+strconv.Itoa(e.Status))
+	}
+	if e.Err != nil {
+		parts = append(parts, e.Err.String())
+	} else if e.Cause != nil {
+		parts = append(parts, e.Cause.Error())
+	}
+	if len(parts) == 0 {
+		return "Request failed"
+	}
+	return strings.Join(parts, ": ")
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Cause
+}
+
+// Kind classifies the error by its HTTP status and the error types
+// returned by Elasticsearch.
+func (e *RequestError) Kind() ErrorKind {
+	var netErr net.Error
+	if e.Status == 0 && e.Err == nil && errors.As(e.Cause, &netErr) {
+		if netErr.Timeout() {
+			return ErrorTimeout
+		}
+		return ErrorConnection
+	}
+	types := e.Err.types()
+	switch {
+	case e.Status == 401 || e.Status == 403 || types["security_exception"]:
+		return ErrorAuthentication
+	case types["index_not_found_exception"] || e.Status == 404 && len(types) == 0:
+		return ErrorIndexNotFound
+	case e.Status == 408 || e.Status == 504 || hasAny(types, timeoutErrors):
+		return ErrorTimeout
+	case hasAny(types, parseErrors):
+		return ErrorQueryParse
+	case e.Status == 0 && e.Err == nil && e.Cause != nil:
+		return ErrorConnection
+	}
+	return ErrorUnknown
+}
+
+// Describe returns a readable multi line description of the error with
+// root causes and failed shards.
+func (e *RequestError) Describe() string {
+	var b strings.Builder
+	b.WriteString("Elasticsearch request failed")
+	if e.Status != 0 {
+		fmt.Fprintf(&b, " with HTTP status %d", e.Status)
+	}
+	fmt.Fprintf(&b, " (%s)\n", e.Kind())
+	if e.Err == nil {
+		if e.Cause != nil {
+			b.WriteString("  " + e.Cause.Error() + "\n")
+		}
+		return b.String()
+	}
+	e.Err.describe(&b, "  ")
+	for _, cause := range e.Err.RootCause {
+		b.WriteString("  root cause: " + cause.String() + "\n")
+	}
+	for _, failure := range e.Err.FailedShards {
+		failure.describe(&b, "  ")
+	}
+	return b.String()
+}
+
+// DescribeError returns a readable description of any error.
+func DescribeError(Err error) string {
+	var requestError *RequestError
+	if errors.As(Err, &requestError) {
+		return requestError.Describe()
+	}
+	var partialError *PartialResultError
+	if errors.As(Err, &partialError) {
+		return partialError.Describe()
+	}
+	return Err.Error() + "\n"
+}
+
+// KindOf returns the kind of an error, ErrorUnknown for errors not
+// returned by requests to Elasticsearch.
+func KindOf(Err error) ErrorKind {
+	var requestError *RequestError
+	if errors.As(Err, &requestError) {
+		return requestError.Kind()
+	}
+	var partialError *PartialResultError
+	if errors.As(Err, &partialError) {
+		return partialError.Kind()
+	}
+	var netErr net.Error
+	if errors.As(Err, &netErr) {
+		if netErr.Timeout() {
+			return ErrorTimeout
+		}
+		return ErrorConnection
+	}
+	return ErrorUnknown
+}
+
+// String returns "type: reason" of the error on one line.
+func (e *ElasticsearchError) String() string {
+	if e == nil {
+		return ""
+	}
+	reason := strings.Join(strings.Fields(e.Reason), " ")
+	if e.Type == "" {
+		return reason
+	}
+	if reason == "" {
+		return e.Type
+	}
+	return e.Type + ": " + reason
+}
+
+func (e *ElasticsearchError) describe(Builder *strings.Builder, Indent string) {
+	Builder.WriteString(Indent + e.String() + "\n")
+	for cause := e.CausedBy; cause != nil; cause = cause.CausedBy {
+		Builder.WriteString(Indent + "caused by: " + cause.String() + "\n")
+	}
+}
+
+// types returns the types of the error, its causes and root causes.
+func (e *ElasticsearchError) types() map[string]bool {
+	types := make(map[string]bool)
+	e.walk(func(e *ElasticsearchError) {
+		if e.Type != "" {
+			types[e.Type] = true
+		}
+	})
+	return types
+}
+
+// mentions returns true if the reason of the error, one of its causes,
+// root causes or failed shards contains the Text.
+func (e *ElasticsearchError) mentions(Text string) bool {
+	found := false
+	e.walk(func(e *ElasticsearchError) {
+		found = found || strings.Contains(e.Reason, Text)
+	})
+	return found
+}
+
+// walk calls fn for the error, its causes, root causes and the reasons of
+// failed shards.
+func (e *ElasticsearchError) walk(fn func(e *ElasticsearchError)) {
+	if e == nil {
+		return
+	}
+	fn(e)
+	e.CausedBy.walk(fn)
+	for i := range e.RootCause {
+		e.RootCause[i].walk(fn)
+	}
+	for i := range e.FailedShards {
+		e.FailedShards[i].Reason.walk(fn)
+	}
+}
+
+func (failure *ElasticsearchShardFailure) describe(Builder *strings.Builder, Indent string) {
+	fmt.Fprintf(Builder, "%sfailed shard %d of index %s", Indent, failure.Shard, failure.Index)
+	if failure.Node != "" {
+		fmt.Fprintf(Builder, " on node %s", failure.Node)
+	}
+	Builder.WriteString(":\n")
+	failure.Reason.describe(Builder, Indent+"  ")
+}
+
+func hasAny(Types map[string]bool, Wanted map[string]bool) bool {
+	for t := range Types {
+		if Wanted[t] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequestErrorKind(t *testing.T) {
+	tests := []struct {
+		file   string
+		body   string
+		err    error
+		status int
+		kind   ErrorKind
+	}{
+		{"es2_error.json", "", errors.New("404 Not Found"), 404, ErrorIndexNotFound},
+		{"es6_error.json", "", errors.New("400 Bad Request"), 400, ErrorQueryParse},
+		{"es7_error.json", "", nil, 400, ErrorQueryParse},
+		{"es8_error.json", "", errors.New("404 Not Found"), 404, ErrorIndexNotFound},
+		{"opensearch_error.json", "", errors.New("403 Forbidden"), 403, ErrorAuthentication},
+		{"", `{"error":{"type":"security_exception","reason":"missing authentication credentials"},"status":401}`, errors.New("401 Unauthorized"), 401, ErrorAuthentication},
+		{"", `{"error":{"type":"process_cluster_event_timeout_exception","reason":"failed to process cluster event"},"status":503}`, errors.New("503 Service Unavailable"), 503, ErrorTimeout},
+		{"", "", errors.New("504 Gateway Timeout"), 0, ErrorConnection},
+		{"", "<html>Bad Gateway</html>", errors.New("502 Bad Gateway"), 0, ErrorConnection},
+		{"", "", errors.New("proxyconnect tcp: dial tcp 10.0.0.200:3128: connection refused"), 0, ErrorConnection},
+		{"", "", errors.New("x509: certificate signed by unknown authority (serial 404)"), 0, ErrorConnection},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d/%s", i, test.file), func(t *testing.T) {
+			body := []byte(test.body)
+			if test.file != "" {
+				var err error
+				body, err = os.ReadFile(filepath.Join("testdata", test.file))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			e := NewRequestError(body, test.err)
+			if e.Status != test.status {
+				t.Errorf("Status = %d, want %d", e.Status, test.status)
+			}
+			if e.Kind() != test.kind {
+				t.Errorf("Kind = %v, want %v", e.Kind(), test.kind)
+			}
+			if KindOf(e) != test.kind {
+				t.Errorf("KindOf = %v, want %v", KindOf(e), test.kind)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pathToken is one step of a field path: a key, an array index or a
+// wildcard matching all keys or elements.
+type pathToken struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Field returns the value of a metadata field (_index, _type, _id, _score,
+// _source) or of a path into the _source of the hit. See LookupPath for
+// the path syntax.
+func (hit *ElasticsearchHitList) Field(Path string) (interface{}, bool) {
+	switch Path {
+	case "_index":
+		return hit.Index, true
+	case "_type":
+		return hit.Type, hit.Type != ""
+	case "_id":
+		return hit.Id, true
+	case "_score":
+		return hit.Score, true
+	case "_source":
+		return hit.Source, true
+	}
+	return LookupPath(hit.Source, Path)
+}
+
+// LookupPath resolves a field path in a document. Path elements are
+// separated by dots, array elements are addressed with [n] (negative n
+// counts from the end) and [*] or * match all elements or keys. Keys
+// containing dots like "host.name" are matched as well. Paths running into
+// an array without index are applied to all of its elements. If the path
+// matches more than one value, a list of all values is returned.
+func LookupPath(Document interface{}, Path string) (interface{}, bool) {
+	tokens, err := parsePath(Path)
+	if err != nil {
+		return nil, false
+	}
+	var values []interface{}
+	multi := collectPath(Document, tokens, &values)
+	if len(values) == 0 {
+		return nil, false
+	}
+	if !multi {
+		return values[0], true
+	}
+	return values, true
+}
+
+// collectPath appends all values matching the tokens to Values. It returns
+// true if the path may match more than one value.
+func collectPath(Value interface{}, Tokens []pathToken, Values *[]interface{}) bool {
+	if len(Tokens) == 0 {
+		*Values = append(*Values, Value)
+		return false
+	}
+	token := Tokens[0]
+	switch v := Value.(type) {
+	case map[string]interface{}:
+		if token.wildcard {
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				collectPath(v[k], Tokens[1:], Values)
+			}
+			return true
+		}
+		if token.isIndex {
+			return false
+		}
+		n := 0
+		for n < len(Tokens) && !Tokens[n].isIndex && !Tokens[n].wildcard {
+			n++
+		}
+		for j := n; j > 0; j-- {
+			keys := make([]string, j)
+			for i := range keys {
+				keys[i] = Tokens[i].key
+			}
+			if e, ok := v[strings.Join(keys, ".")]; ok {
+				return collectPath(e, Tokens[j:], Values)
+			}
+		}
+	case []interface{}:
+		if token.wildcard {
+			for _, e := range v {
+				collectPath(e, Tokens[1:], Values)
+			}
+			return true
+		}
+		index := token.index
+		isIndex := token.isIndex
+		if !isIndex {
+			if i, err := strconv.Atoi(token.key); err == nil {
+				index, isIndex = i, true
+			}
+		}
+		if isIndex {
+			if index < 0 {
+				index += len(v)
+			}
+			if index < 0 || index >= len(v) {
+				return false
+			}
+			return collectPath(v[index], Tokens[1:], Values)
+		}
+		for _, e := range v {
+			collectPath(e, Tokens, Values)
+		}
+		return true
+	}
+	return false
+}
+
+// parsePath splits a path like "items[*].tags[0]" into tokens.
+func parsePath(Path string) ([]pathToken, error) {
+	var tokens []pathToken
+	var key strings.Builder
+	flush := func() {
+		if key.Len() == 0 {
+			return
+		}
+		k := key.String()
+		key.Reset()
+		if k == "*" {
+			tokens = append(tokens, pathToken{wildcard: true})
+			return
+		}
+		tokens = append(tokens, pathToken{key: k})
+	}
+	for i := 0; i < len(Path); i++ {
+		switch c := Path[i]; c {
+		case '.':
+			flush()
+		case '[':
+			flush()
+			end := strings.IndexByte(Path[i:], ']')
+			if end < 0 {
+				return nil, errors.New("Missing ']' in path '" + Path + "'")
+			}
+			inner := Path[i+1 : i+end]
+			i += end
+			if inner == "*" {
+				tokens = append(tokens, pathToken{wildcard: true})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, errors.New("Invalid index '" + inner + "' in path '" + Path + "'")
+			}
+			tokens = append(tokens, pathToken{index: index, isIndex: true})
+		default:
+			key.WriteByte(c)
+		}
+	}
+	flush()
+	if len(tokens) == 0 {
+		return nil, errors.New("Empty path")
+	}
+	return tokens, nil
+}
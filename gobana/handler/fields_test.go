@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testDocument = `{
+	"host": {"name": "web1", "ip": ["10.0.0.1", "10.0.0.2"]},
+	"service.name": "shop",
+	"labels": {"env.stage": "prod"},
+	"items": [
+		{"id": 1, "tags": ["a", "b"]},
+		{"id": 2, "tags": ["c"]},
+		{"id": 3}
+	],
+	"matrix": [[1, 2], [3, 4]],
+	"price": 1.5,
+	"big": 12345678901
+}`
+
+func testSource(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var source map[string]interface{}
+	if err := json.Unmarshal([]byte(testDocument), &source); err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path   string
+		tokens []pathToken
+		err    bool
+	}{
+		{"host.name", []pathToken{{key: "host"}, {key: "name"}}, false},
+		{"items[0].id", []pathToken{{key: "items"}, {index: 0, isIndex: true}, {key: "id"}}, false},
+		{"items[-1]", []pathToken{{key: "items"}, {index: -1, isIndex: true}}, false},
+		{"items[*].tags", []pathToken{{key: "items"}, {wildcard: true}, {key: "tags"}}, false},
+		{"labels.*", []pathToken{{key: "labels"}, {wildcard: true}}, false},
+		{"matrix[1][0]", []pathToken{{key: "matrix"}, {index: 1, isIndex: true}, {index: 0, isIndex: true}}, false},
+		{"items[", nil, true},
+		{"items[x]", nil, true},
+		{"", nil, true},
+		{"..", nil, true},
+	}
+	for _, test := range tests {
+		tokens, err := parsePath(test.path)
+		if (err != nil) != test.err {
+			t.Errorf("parsePath(%q) error = %v, want error %v", test.path, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens, test.tokens) {
+			t.Errorf("parsePath(%q) = %+v, want %+v", test.path, tokens, test.tokens)
+		}
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	source := testSource(t)
+	tests := []struct {
+		path  string
+		want  string
+		found bool
+	}{
+		{"host.name", `"web1"`, true},
+		{"host.ip", `["10.0.0.1","10.0.0.2"]`, true},
+		{"host.ip[1]", `"10.0.0.2"`, true},
+		{"host.ip.0", `"10.0.0.1"`, true},
+		{"host.ip[-1]", `"10.0.0.2"`, true},
+		{"host.ip[-3]", ``, false},
+		{"host.ip[2]", ``, false},
+		{"service.name", `"shop"`, true},
+		{"labels.env.stage", `"prod"`, true},
+		{"items[*].id", `[1,2,3]`, true},
+		{"items.id", `[1,2,3]`, true},
+		{"items[*].tags", `[["a","b"],["c"]]`, true},
+		{"items[*].tags[0]", `["a","c"]`, true},
+		{"items[-1].id", `3`, true},
+		{"items[*].missing", ``, false},
+		{"matrix[1][0]", `3`, true},
+		{"host.*", `[["10.0.0.1","10.0.0.2"],"web1"]`, true},
+		{"host.name.first", ``, false},
+		{"host[0]", ``, false},
+		{"missing", ``, false},
+		{"items[x]", ``, false},
+	}
+	for _, test := range tests {
+		value, found := LookupPath(source, test.path)
+		if found != test.found {
+			t.Errorf("LookupPath(%q) found = %v, want %v", test.path, found, test.found)
+			continue
+		}
+		if !found {
+			continue
+		}
+		data, _ := json.Marshal(value)
+		if string(data) != test.want {
+			t.Errorf("LookupPath(%q) = %s, want %s", test.path, data, test.want)
+		}
+	}
+}
+
+func TestCollectPath(t *testing.T) {
+	source := testSource(t)
+	tests := []struct {
+		path  string
+		count int
+		multi bool
+	}{
+		{"host.name", 1, false},
+		{"items[0].tags", 1, false},
+		{"items[*].id", 3, true},
+		{"items.tags", 2, true},
+		{"labels.*", 1, true},
+		{"missing", 0, false},
+	}
+	for _, test := range tests {
+		tokens, err := parsePath(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var values []interface{}
+		multi := collectPath(source, tokens, &values)
+		if len(values) != test.count || multi != test.multi {
+			t.Errorf("collectPath(%q) = %d values, multi %v, want %d, %v", test.path, len(values), multi, test.count, test.multi)
+		}
+	}
+}
+
+func TestHitField(t *testing.T) {
+	hit := &ElasticsearchHitList{Index: "logs", Id: "1", Source: testSource(t)}
+	if value, ok := hit.Field("_index"); !ok || value != "logs" {
+		t.Errorf("Field(_index) = %v, %v", value, ok)
+	}
+	if _, ok := hit.Field("_type"); ok {
+		t.Error("Field(_type) found without type")
+	}
+	if value, ok := hit.Field("host.name"); !ok || value != "web1" {
+		t.Errorf("Field(host.name) = %v, %v", value, ok)
+	}
+}
+
+func TestSingleValueFormat(t *testing.T) {
+	result := &ElasticsearchResult{Hits: ElasticsearchHitResult{Hits: []ElasticsearchHitList{
+		{Id: "1", Source: testSource(t)},
+		{Id: "2", Source: map[string]interface{}{"other": true}},
+	}}}
+	var buf bytes.Buffer
+	err := result.SingleValue(&buf, []string{"host", "items[*].id", "price", "big", "labels.env.stage", "missing"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1:{\"ip\":[\"10.0.0.1\",\"10.0.0.2\"],\"name\":\"web1\"}\t[1,2,3]\t1.5\t12345678901\tprod\t\n"
+	if buf.String() != want {
+		t.Errorf("SingleValue = %q, want %q", buf.String(), want)
+	}
+}
@@ -2,113 +2,348 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"path/filepath"
+	"sort"
 	"strings"
-	"text/template"
-	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/joernott/elasticsearch-tools/gobana/query"
 	"github.com/joernott/lra"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
+// DefaultEndpoint is used when no endpoint has been configured.
+const DefaultEndpoint = "_search"
+
 type Gobana struct {
 	Connection *lra.Connection
+	Method     string
+	Endpoint   string
+	Query      string
+	KeepAlive  string
+	Slices     int
+	Ordered    bool
+	OnPartial  string
+	Warnings   io.Writer
+
+	searchTemplate bool
+}
+
+// GobanaOptions configures a Gobana. Query, Queryfile, KQL and Lucene are
+// exclusive. With Toml or DataFiles, the query is rendered as template with
+// the key=value pairs in Data, the DataFiles and the partials in the
+// Library directory, see RenderTemplate. TemplateId runs a stored search
+// template, Mustache sends the query or queryfile as inline Mustache
+// template, both with the Data and DataFiles as params. KQL is translated
+// into the query DSL, Lucene sent as query_string query. A TimeRange wraps
+// the query in a bool filter with a range clause. Size, Sort (like
+// "@timestamp:desc") and Fields (the _source fields to return) replace the
+// settings of the query. Method is the HTTP method used by Execute, POST if
+// empty. Warnings receives the description of partial results when
+// OnPartial is PartialWarn, nil only logs them.
+type GobanaOptions struct {
+	Connection *lra.Connection
+	Method     string
 	Endpoint   string
 	Query      string
+	Queryfile  string
+	Toml       bool
+	Data       []string
+	DataFiles  []string
+	Library    string
+	KQL        string
+	Lucene     string
+	TemplateId string
+	Mustache   bool
+	TimeRange  query.TimeRange
+	Size       *int
+	Sort       []string
+	Fields     []string
+	KeepAlive  string
+	Slices     int
+	Ordered    bool
+	OnPartial  string
+	Warnings   io.Writer
 }
 
+// ElasticsearchResult is the decoded response of a search. Body holds the
+// response as received, the only content of responses which are no json
+// object like the text output of the cat APIs.
 type ElasticsearchResult struct {
 	Took         int                          `json:"took"`
 	TimedOut     bool                         `json:"timed_out"`
 	Shards       ElasticsearchShardResult     `json:"_shards"`
 	Hits         ElasticsearchHitResult       `json:"hits"`
-	Error        string                       `json:"error"`
-	Status       int                          `json:"status`
+	Error        *ElasticsearchError          `json:"error,omitempty"`
+	Status       int                          `json:"status,omitempty"`
 	Aggregations map[string]AggregationResult `json:"aggregations"`
+	PitId        string                       `json:"pit_id,omitempty"`
+	ScrollId     string                       `json:"_scroll_id,omitempty"`
+	Count        int64                        `json:"count,omitempty"`
+	Body         []byte                       `json:"-"`
 }
 
 type ElasticsearchShardResult struct {
-	Total      int `json:"total"`
-	Successful int `json:"successful"`
-	Skipped    int `json:"skipped"`
-	Failed     int `json:"failed"`
+	Total      int                         `json:"total"`
+	Successful int                         `json:"successful"`
+	Skipped    int                         `json:"skipped"`
+	Failed     int                         `json:"failed"`
+	Failures   []ElasticsearchShardFailure `json:"failures,omitempty"`
 }
 
 type ElasticsearchHitResult struct {
-	Total    int64                  `json:"total"`
+	Total    ElasticsearchTotal     `json:"total"`
 	MaxScore float64                `json:"max_score"`
 	Hits     []ElasticsearchHitList `json:"hits"`
 }
 
+// ElasticsearchTotal is the number of hits. Elasticsearch 7 and later return
+// an object with the value and its relation ("eq" or "gte"), older versions
+// a plain number.
+type ElasticsearchTotal struct {
+	Value    int64  `json:"value"`
+	Relation string `json:"relation"`
+}
+
+// ElasticsearchError is the error returned by Elasticsearch. Current
+// versions return an object with root causes and nested causes, very old
+// ones a plain string which is stored as Reason.
+type ElasticsearchError struct {
+	Type         string                      `json:"type,omitempty"`
+	Reason       string                      `json:"reason,omitempty"`
+	Index        string                      `json:"index,omitempty"`
+	Phase        string                      `json:"phase,omitempty"`
+	RootCause    []ElasticsearchError        `json:"root_cause,omitempty"`
+	CausedBy     *ElasticsearchError         `json:"caused_by,omitempty"`
+	FailedShards []ElasticsearchShardFailure `json:"failed_shards,omitempty"`
+}
+
+// ElasticsearchHitList is one hit. RawSource keeps the undecoded _source,
+// so it can be decoded into other types, see DecodeHits.
 type ElasticsearchHitList struct {
-	Index  string                 `json:"_index"`
-	Type   string                 `json:"_type"`
-	Id     string                 `json:"_id"`
-	Score  float64                `json:"_score"`
-	Source map[string]interface{} `json:"_source"`
+	Index     string                            `json:"_index"`
+	Type      string                            `json:"_type"`
+	Id        string                            `json:"_id"`
+	Score     float64                           `json:"_score"`
+	Source    map[string]interface{}            `json:"_source"`
+	RawSource json.RawMessage                   `json:"-"`
+	Sort      []interface{}                     `json:"sort,omitempty"`
+	Highlight map[string][]string               `json:"highlight,omitempty"`
+	InnerHits map[string]ElasticsearchInnerHits `json:"inner_hits,omitempty"`
+}
+
+// ElasticsearchInnerHits are the inner hits of one name.
+type ElasticsearchInnerHits struct {
+	Hits ElasticsearchHitResult `json:"hits"`
 }
 
 type AggregationResult map[string]interface{}
 
-func NewGobana(UseSSL bool, Server string, Port int, User string, Password string, ValidateSSL bool, Proxy string, ProxyIsSocks bool, Timeout uint, Query string, Queryfile string, Toml bool, Data []string, Endpoint string) (*Gobana, error) {
-	var g *Gobana
+func (total *ElasticsearchTotal) UnmarshalJSON(Data []byte) error {
+	data := bytes.TrimSpace(Data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '{' {
+		type plain ElasticsearchTotal
+		return json.Unmarshal(data, (*plain)(total))
+	}
+	total.Relation = "eq"
+	return json.Unmarshal(data, &total.Value)
+}
+
+// UnmarshalJSON decodes the hit. The sort values are decoded as
+// json.Number, so they can be passed to search_after unchanged.
+func (hit *ElasticsearchHitList) UnmarshalJSON(Data []byte) error {
+	type plain ElasticsearchHitList
+	aux := struct {
+		*plain
+		Source json.RawMessage `json:"_source"`
+		Sort   json.RawMessage `json:"sort"`
+	}{plain: (*plain)(hit)}
+	err := json.Unmarshal(Data, &aux)
+	if err != nil {
+		return err
+	}
+	hit.Sort = nil
+	if len(aux.Sort) > 0 {
+		err = decodeNumbers(aux.Sort, &hit.Sort)
+		if err != nil {
+			return err
+		}
+	}
+	hit.Source = nil
+	hit.RawSource = nil
+	if len(aux.Source) == 0 || bytes.Equal(aux.Source, []byte("null")) {
+		return nil
+	}
+	hit.RawSource = aux.Source
+	return json.Unmarshal(aux.Source, &hit.Source)
+}
+
+func (e *ElasticsearchError) UnmarshalJSON(Data []byte) error {
+	data := bytes.TrimSpace(Data)
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &e.Reason)
+	}
+	type plain ElasticsearchError
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// NewGobana creates a Gobana from the options. Endpoint defaults to
+// DefaultEndpoint and OnPartial to PartialWarn.
+func NewGobana(Options GobanaOptions) (*Gobana, error) {
 	var err error
 
 	logger := log.WithField("func", "NewGobana")
-	g = new(Gobana)
-
-	hdr := make(lra.HeaderList)
-	hdr["Content-Type"] = "application/json"
-	Connection, err := lra.NewConnection(UseSSL,
-		Server,
-		Port,
-		User,
-		Password,
-		"",
-		ValidateSSL,
-		Proxy,
-		ProxyIsSocks,
-		hdr,
-		time.Duration(Timeout),
-	)
+	if Options.Connection == nil {
+		err = errors.New("No connection to Elasticsearch given")
+		logger.Error(err)
+		return nil, err
+	}
+	g := &Gobana{
+		Connection: Options.Connection,
+		Method:     strings.ToUpper(Options.Method),
+		Endpoint:   Options.Endpoint,
+		KeepAlive:  Options.KeepAlive,
+		Slices:     Options.Slices,
+		Ordered:    Options.Ordered,
+		OnPartial:  Options.OnPartial,
+		Warnings:   Options.Warnings,
+	}
+	if g.Endpoint == "" {
+		g.Endpoint = DefaultEndpoint
+	}
+	if g.Method == "" {
+		g.Method = http.MethodPost
+	}
+	if !isMethod(g.Method) {
+		err = errors.New("Unsupported method '" + Options.Method + "', use one of " + strings.Join(Methods, ", "))
+		logger.Error(err)
+		return nil, err
+	}
+	if g.OnPartial == "" {
+		g.OnPartial = PartialWarn
+	}
+	err = ValidatePartialPolicy(g.OnPartial)
 	if err != nil {
 		logger.Error(err)
 		return nil, err
 	}
-	g.Connection = Connection
-	g.Endpoint = Endpoint
-	if Queryfile != "" {
-		if Query != "" {
-			logger.WithFields(log.Fields{
-				"Query":     Query,
-				"QueryFile": Queryfile,
-			}).Error("Can't use exclusive parameters query and queryfile at the same time")
-			err := errors.New("Can't use exclusive parameters query and queryfile at the same time")
+	var given []string
+	for name, value := range map[string]string{
+		"query":       Options.Query,
+		"queryfile":   Options.Queryfile,
+		"kql":         Options.KQL,
+		"lucene":      Options.Lucene,
+		"template-id": Options.TemplateId,
+	} {
+		if value != "" {
+			given = append(given, name)
+		}
+	}
+	if len(given) > 1 {
+		sort.Strings(given)
+		err = errors.New("Can't use exclusive parameters " + strings.Join(given, " and ") + " at the same time")
+		logger.WithField("parameters", given).Error(err)
+		return nil, err
+	}
+	Query := Options.Query
+	if Options.Queryfile != "" {
+		Query, err = getQueryFromQueryfile(Options.Queryfile)
+		if err != nil {
 			return nil, err
 		}
-		Query, err = getQueryFromQueryfile(Queryfile)
+	}
+	if Options.TemplateId != "" || Options.Mustache {
+		Query, err = searchTemplateQuery(Query, Options)
 		if err != nil {
+			logger.Error(err)
 			return nil, err
 		}
+		g.Endpoint = searchTemplateEndpoint(g.Endpoint)
+		g.Query = Query
+		g.searchTemplate = true
+		logger.Debug(g.Query)
+		return g, nil
 	}
-	if Toml {
-		Query, err = parseToml(Query, Data)
+	if Options.Toml || len(Options.DataFiles) > 0 {
+		Query, err = RenderTemplate(Query, TemplateOptions{
+			Data:      Options.Data,
+			DataFiles: Options.DataFiles,
+			Library:   Options.Library,
+		})
 		if err != nil {
 			logger.Error(err)
 			return nil, err
 		}
 	}
+	if Options.KQL != "" {
+		q, err := query.KQL(Options.KQL)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+		Query = query.NewSearch().Query(q).String()
+	}
+	if Options.Lucene != "" {
+		Query = query.NewSearch().Query(query.QueryString(Options.Lucene).AnalyzeWildcard(true)).String()
+	}
+	Query, err = applyOptions(Query, Options)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
 	g.Query = Query
 	logger.Debug(g.Query)
 	return g, nil
 }
 
+// applyOptions restricts the query to the time range and replaces size,
+// sort and _source by the options. The query is returned unchanged if none
+// of them is set.
+func applyOptions(Query string, Options GobanaOptions) (string, error) {
+	if Options.TimeRange.IsZero() && Options.Size == nil && len(Options.Sort) == 0 && len(Options.Fields) == 0 {
+		return Query, nil
+	}
+	body, err := queryBody(Query)
+	if err != nil {
+		return "", err
+	}
+	if !Options.TimeRange.IsZero() {
+		r, err := Options.TimeRange.Query()
+		if err != nil {
+			return "", err
+		}
+		query.Filter(body, r)
+	}
+	search := query.NewSearch()
+	if Options.Size != nil {
+		search.Size(*Options.Size)
+	}
+	if len(Options.Sort) > 0 {
+		search.SortBy(Options.Sort...)
+	}
+	if len(Options.Fields) > 0 {
+		search.Source(Options.Fields, nil)
+	}
+	for k, v := range search.Body() {
+		body[k] = v
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 func getQueryFromQueryfile(Queryfile string) (string, error) {
 	logger := log.WithField("func", "getQueryFromQueryfile")
 	logger.Debug("Reading query from file '" + Queryfile + "'.")
@@ -127,61 +362,129 @@ func getQueryFromQueryfile(Queryfile string) (string, error) {
 	return string(buf), nil
 }
 
-func parseToml(Query string, Data []string) (string, error) {
-	var Fields map[string]string
-	logger := log.WithField("func", "parseToml")
-
-	Fields = make(map[string]string)
-	for _, d := range Data {
-		x := strings.SplitN(d, "=", 2)
-		Fields[x[0]] = x[1]
+// Execute sends the query to the endpoint using the method of the Gobana,
+// POST if it is empty. If ctx is cancelled before the
+// response arrives, ctx.Err() is returned.
+func (gobana *Gobana) Execute(ctx context.Context) (*ElasticsearchResult, error) {
+	logger := log.WithField("func", "Gobana.Execute")
+	method := gobana.Method
+	if method == "" {
+		method = http.MethodPost
 	}
-	logger.Debug("Parsing TOML")
-	t := template.New("query")
-	t, err := t.Parse(Query)
+	logger.WithFields(log.Fields{"query": gobana.Query, "method": method, "endpoint": gobana.Endpoint}).Debug("Execute")
+	ResultJson, err := gobana.send(ctx, method, gobana.Endpoint, []byte(gobana.Query))
 	if err != nil {
 		logger.Error(err)
-		return "", err
+		return nil, err
 	}
-	var buf bytes.Buffer
-	err = t.Execute(&buf, Fields)
-	if err != nil {
-		logger.Error(err)
-		return "", err
+	logger.Info("Successfully executed query")
+	if log.IsLevelEnabled(log.DebugLevel) {
+		debugOut, err := json.MarshalIndent(ResultJson, "", "  ")
+		if err != nil {
+			logger.Error(err)
+		}
+		log.Debug(string(debugOut))
+		log.Debug(spew.Sdump(ResultJson))
 	}
-	return buf.String(), nil
+	return ResultJson, nil
 }
 
-func (gobana *Gobana) Execute() (*ElasticsearchResult, error) {
-	var ResultJson *ElasticsearchResult
-
-	ResultJson = new(ElasticsearchResult)
+// Count returns the number of documents matching the query using the
+// _count API of the Endpoint. Only the "query" of the query is sent, as
+// _count rejects size, sort, aggregations and the other search settings.
+func (gobana *Gobana) Count(ctx context.Context) (int64, error) {
+	logger := log.WithField("func", "Gobana.Count")
 
-	logger := log.WithField("func", "Gobana.Execute")
-	logger.WithFields(log.Fields{"query": gobana.Query, "endpoint": gobana.Endpoint}).Debug("Execute")
-	result, err := gobana.Connection.Post("/"+gobana.Endpoint, []byte(gobana.Query))
-	if err != nil {
+	if gobana.searchTemplate {
+		err := errors.New("Can't count with a search template, use a query instead")
 		logger.Error(err)
-		return nil, err
+		return 0, err
 	}
-	logger.Info("Successfully executed query")
-	err = json.Unmarshal(result, ResultJson)
+	body, err := queryBody(gobana.Query)
 	if err != nil {
 		logger.Error(err)
-		return nil, err
+		return 0, err
 	}
-	ll := viper.GetInt("loglevel")
-	if ll == 5 {
-		debugOut, err := json.MarshalIndent(ResultJson, "", "  ")
+	var data []byte
+	if q, ok := body["query"]; ok {
+		data, err = json.Marshal(map[string]interface{}{"query": q})
 		if err != nil {
 			logger.Error(err)
+			return 0, err
 		}
-		log.Debug(string(debugOut))
-		spew.Dump(ResultJson)
+	}
+	result, err := gobana.send(ctx, http.MethodPost, gobana.Endpoint, data)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// send sends the query to the endpoint with the method and decodes the
+// result. Responses which are no json object are only kept in the Body of
+// the result. Errors returned by Elasticsearch are returned as
+// *RequestError.
+func (gobana *Gobana) send(ctx context.Context, Method string, Endpoint string, Query []byte) (*ElasticsearchResult, error) {
+	response, err := Request(ctx, gobana.Connection, Method, "/"+Endpoint, Query)
+	if err != nil {
+		return nil, err
+	}
+	ResultJson := &ElasticsearchResult{Body: response.Body}
+	if !bytes.HasPrefix(bytes.TrimSpace(response.Body), []byte("{")) {
+		return ResultJson, nil
+	}
+	err = json.Unmarshal(response.Body, ResultJson)
+	if err != nil {
+		return nil, err
+	}
+	if ResultJson.Error != nil {
+		return nil, NewRequestError(response.Body, nil)
+	}
+	err = gobana.checkPartial(ResultJson)
+	if err != nil {
+		return nil, err
 	}
 	return ResultJson, nil
 }
 
+// IsSearchEndpoint returns true for endpoints returning search results,
+// like "logs/_search?routing=web1" or "_search/template".
+func IsSearchEndpoint(Endpoint string) bool {
+	path, _, _ := strings.Cut(strings.TrimPrefix(Endpoint, "/"), "?")
+	path = strings.TrimSuffix(path, "/")
+	for _, api := range []string{"_search", "_search/template", "_search/scroll"} {
+		if path == api || strings.HasSuffix(path, "/"+api) {
+			return true
+		}
+	}
+	return false
+}
+
+// withContext runs the request in the background and waits for it or
+// for ctx to be done, whatever happens first. The connection can't abort
+// a running request, so a cancelled request finishes unobserved.
+func withContext(ctx context.Context, Request func() ([]byte, error)) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type response struct {
+		data []byte
+		err  error
+	}
+	done := make(chan response, 1)
+	go func() {
+		data, err := Request()
+		done <- response{data, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		return r.data, r.err
+	}
+}
+
 func (result *ElasticsearchResult) WriteFile(FileName string) error {
 	logger := log.WithField("func", "ElasticsearchResult.WriteFile")
 	output, err := json.Marshal(result)
@@ -190,49 +493,101 @@ func (result *ElasticsearchResult) WriteFile(FileName string) error {
 		return err
 	}
 	err = ioutil.WriteFile(FileName, output, 0644)
-	return nil
+	if err != nil {
+		logger.Error(err)
+	}
+	return err
+}
+
+// WriteJson writes the result as one line of json to the writer.
+func (result *ElasticsearchResult) WriteJson(Writer io.Writer) error {
+	logger := log.WithField("func", "ElasticsearchResult.WriteJson")
+	output, err := json.Marshal(result)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	_, err = Writer.Write(append(output, '\n'))
+	if err != nil {
+		logger.Error(err)
+	}
+	return err
 }
 
-func (result *ElasticsearchResult) SingleValue(FieldName string) {
+// SingleValue writes the values of the fields of every hit as one tab
+// separated line to the writer, prefixed with the id of the hit unless
+// ValueOnly is set.
+func (result *ElasticsearchResult) SingleValue(Writer io.Writer, FieldNames []string, ValueOnly bool) error {
 	var found int64
-	var ok bool
 
 	logger := log.WithField("func", "ElasticsearchResult.SingleValue")
 	logger.Info("Collecting single values")
-	for _, hit := range result.Hits.Hits {
-		s := hit.Source
-		if result, ok := s[FieldName]; ok {
-			if !viper.GetBool("valueonly") {
-				fmt.Print(hit.Id + ":")
+	for i := range result.Hits.Hits {
+		hit := &result.Hits.Hits[i]
+		values := make([]string, len(FieldNames))
+		hasField := false
+		for j, fieldName := range FieldNames {
+			if value, ok := hit.Field(fieldName); ok {
+				values[j] = FormatValue(value)
+				hasField = true
+			}
+		}
+		if hasField {
+			line := strings.Join(values, "\t")
+			if !ValueOnly {
+				line = hit.Id + ":" + line
+			}
+			_, err := fmt.Fprintln(Writer, line)
+			if err != nil {
+				logger.Error(err)
+				return err
 			}
-			fmt.Println(result)
 			found++
 		}
 		log.WithFields(log.Fields{
 			"Index":    hit.Index,
 			"Type":     hit.Type,
-			"HasField": ok,
-			"Value":    result}).Debug("Processed " + hit.Id)
+			"HasField": hasField,
+			"Value":    values}).Debug("Processed " + hit.Id)
 	}
 	logger.WithField("Found", found).Info("Finished collecting single values")
+	return nil
 }
 
-func (result *ElasticsearchResult) GetAggregation(FieldName string) {
+// GetAggregation writes the values of the aggregations found by the path
+// (see AggregationPath) to the writer, one per line. Unless ValueOnly is
+// set, the values are prefixed with the keys of the buckets on the way and
+// their own label, separated by ">": the bucket key for the doc_count of
+// bucket aggregations, "value" for single value metrics or the name of the
+// value for multi value metrics.
+func (result *ElasticsearchResult) GetAggregation(Writer io.Writer, Path string, ValueOnly bool) error {
 	logger := log.WithField("func", "ElasticsearchResult.GetAggregation")
 	logger.Info("Collecting aggregation")
-	if a, ok := result.Aggregations[FieldName]; ok {
-		for k, v := range a {
-			if !viper.GetBool("valueonly") {
-				fmt.Printf("%v:", k)
+	matches, err := result.AggregationPath(Path)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if len(matches) == 0 {
+		logger.WithField("aggregation", Path).Warn("Aggregation not found")
+		return nil
+	}
+	for _, match := range matches {
+		for _, line := range match.Aggregation.lines() {
+			output := FormatValue(line.value)
+			if !ValueOnly {
+				label := append(append([]string{}, match.Keys...), line.label)
+				output = strings.Join(label, ">") + ":" + output
+			}
+			_, err := fmt.Fprintln(Writer, output)
+			if err != nil {
+				logger.Error(err)
+				return err
 			}
-			fmt.Printf("%v\n", v)
 			log.WithFields(log.Fields{
-				"Key":   k,
-				"Value": v}).Debug("Processed Aggregation " + FieldName)
+				"Key":   line.label,
+				"Value": line.value}).Debug("Processed Aggregation " + Path)
 		}
-
-	} else {
-		logger.WithField("aggregation", FieldName).Warn("Aggregation not found")
 	}
-
+	return nil
 }
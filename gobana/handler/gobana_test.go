@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readResult(t *testing.T, Name string) *ElasticsearchResult {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(ElasticsearchResult)
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		t.Fatalf("Unmarshal %s: %v", Name, err)
+	}
+	return result
+}
+
+func TestElasticsearchResultSearch(t *testing.T) {
+	tests := []struct {
+		file     string
+		total    int64
+		relation string
+		hits     int
+		id       string
+		timedOut bool
+		failed   int
+	}{
+		{"es6_search.json", 2, "eq", 2, "AWmN3tGXkq9Lg2rJ8xYz", false, 0},
+		{"es7_search.json", 10000, "gte", 1, "b2yC3ocBzJ5eV1pW4q7N", false, 0},
+		{"es8_search.json", 3, "eq", 1, "x9tKE5MBr4mQz8cP1aVb", false, 0},
+		{"opensearch_search.json", 1, "eq", 1, "1", true, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			result := readResult(t, test.file)
+			if result.Hits.Total.Value != test.total {
+				t.Errorf("Total.Value = %d, want %d", result.Hits.Total.Value, test.total)
+			}
+			if result.Hits.Total.Relation != test.relation {
+				t.Errorf("Total.Relation = %q, want %q", result.Hits.Total.Relation, test.relation)
+			}
+			if len(result.Hits.Hits) != test.hits {
+				t.Fatalf("len(Hits) = %d, want %d", len(result.Hits.Hits), test.hits)
+			}
+			if result.Hits.Hits[0].Id != test.id {
+				t.Errorf("Hits[0].Id = %q, want %q", result.Hits.Hits[0].Id, test.id)
+			}
+			if result.TimedOut != test.timedOut {
+				t.Errorf("TimedOut = %v, want %v", result.TimedOut, test.timedOut)
+			}
+			if result.Shards.Failed != test.failed {
+				t.Errorf("Shards.Failed = %d, want %d", result.Shards.Failed, test.failed)
+			}
+			if result.Error != nil {
+				t.Errorf("Error = %+v, want nil", result.Error)
+			}
+		})
+	}
+}
+
+func TestElasticsearchResultError(t *testing.T) {
+	tests := []struct {
+		file      string
+		status    int
+		errType   string
+		reason    string
+		rootCause string
+		causedBy  string
+	}{
+		{"es2_error.json", 404, "", "IndexMissingException[[missing] missing]", "", ""},
+		{"es6_error.json", 400, "search_phase_execution_exception", "all shards failed", "query_shard_exception", ""},
+		{"es7_error.json", 400, "parsing_exception", "unknown query [matc]", "parsing_exception", "named_object_not_found_exception"},
+		{"es8_error.json", 404, "index_not_found_exception", "no such index [missing]", "index_not_found_exception", ""},
+		{"opensearch_error.json", 403, "security_exception", "no permissions for [indices:data/read/search] and User [name=reader, backend_roles=[], requestedTenant=null]", "security_exception", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			result := readResult(t, test.file)
+			if result.Status != test.status {
+				t.Errorf("Status = %d, want %d", result.Status, test.status)
+			}
+			if result.Error == nil {
+				t.Fatal("Error is nil")
+			}
+			if result.Error.Type != test.errType {
+				t.Errorf("Error.Type = %q, want %q", result.Error.Type, test.errType)
+			}
+			if result.Error.Reason != test.reason {
+				t.Errorf("Error.Reason = %q, want %q", result.Error.Reason, test.reason)
+			}
+			rootCause := ""
+			if len(result.Error.RootCause) > 0 {
+				rootCause = result.Error.RootCause[0].Type
+			}
+			if rootCause != test.rootCause {
+				t.Errorf("Error.RootCause[0].Type = %q, want %q", rootCause, test.rootCause)
+			}
+			causedBy := ""
+			if result.Error.CausedBy != nil {
+				causedBy = result.Error.CausedBy.Type
+			}
+			if causedBy != test.causedBy {
+				t.Errorf("Error.CausedBy.Type = %q, want %q", causedBy, test.causedBy)
+			}
+		})
+	}
+}
+
+func TestElasticsearchTotalMarshal(t *testing.T) {
+	result := readResult(t, "es6_search.json")
+	data, err := json.Marshal(result.Hits.Total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"value":2,"relation":"eq"}`
+	if string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+}
+
+func TestSingleValue(t *testing.T) {
+	result := readResult(t, "es8_search.json")
+	tests := []struct {
+		valueOnly bool
+		want      string
+	}{
+		{false, "x9tKE5MBr4mQz8cP1aVb:404\t2024-11-04T13:02:45.120Z\n"},
+		{true, "404\t2024-11-04T13:02:45.120Z\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := result.SingleValue(&buf, []string{"http.response.status_code", "@timestamp"}, test.valueOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("SingleValue(valueOnly=%v) = %q, want %q", test.valueOnly, buf.String(), test.want)
+		}
+	}
+}
+
+func TestExecuteCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g := &Gobana{Endpoint: DefaultEndpoint}
+	_, err := g.Execute(ctx)
+	if err != context.Canceled {
+		t.Errorf("Execute = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`{"size":5,"sort":["@timestamp"],"aggs":{"x":{"terms":{"field":"y"}}},"query":{"term":{"id":9007199254740993}}}`, `{"query":{"term":{"id":9007199254740993}}}`},
+		{`{"size":5}`, ``},
+		{``, ``},
+	}
+	for _, test := range tests {
+		var body string
+		server := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			body = string(data)
+			w.Write([]byte(`{"count":42,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0}}`))
+		})
+		g := newTestGobana(t, server, test.query)
+		g.Endpoint = "logs/_count"
+		count, err := g.Count(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 42 || body != test.want {
+			t.Errorf("Count(%s) = %d with body %s, want 42 with body %s", test.query, count, body, test.want)
+		}
+	}
+}
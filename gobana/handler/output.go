@@ -0,0 +1,379 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"go.yaml.in/yaml/v3"
+)
+
+// Supported output formats
+const (
+	OutputJson   = "json"
+	OutputNdjson = "ndjson"
+	OutputYaml   = "yaml"
+	OutputCsv    = "csv"
+	OutputTsv    = "tsv"
+	OutputTable  = "table"
+)
+
+// OutputFormats lists all formats known to NewFormatter.
+var OutputFormats = []string{OutputJson, OutputNdjson, OutputYaml, OutputCsv, OutputTsv, OutputTable}
+
+// Record is one row of output. Fields holds the column names in order,
+// Values the value for each column.
+type Record struct {
+	Fields []string
+	Values map[string]interface{}
+}
+
+// Formatter writes records in one output format. Close must be called
+// after the last record to complete the output.
+type Formatter interface {
+	WriteRecord(record Record) error
+	Close() error
+}
+
+// NewFormatter creates a formatter for the given format. If Columns is
+// empty, json, ndjson and yaml output the complete record, csv and tsv use
+// the fields of the first record as columns and table collects the fields
+// of all records in the order they first appear.
+// Header controls the header row of csv, tsv and table output.
+func NewFormatter(Format string, Writer io.Writer, Columns []string, Header bool) (Formatter, error) {
+	logger := log.WithField("func", "NewFormatter")
+	switch strings.ToLower(Format) {
+	case OutputJson:
+		return &jsonFormatter{writer: Writer, columns: Columns}, nil
+	case OutputNdjson:
+		return &ndjsonFormatter{writer: Writer, columns: Columns}, nil
+	case OutputYaml:
+		return &yamlFormatter{writer: Writer, columns: Columns}, nil
+	case OutputCsv:
+		return newCsvFormatter(Writer, ',', Columns, Header), nil
+	case OutputTsv:
+		return newCsvFormatter(Writer, '\t', Columns, Header), nil
+	case OutputTable:
+		return &tableFormatter{
+			writer:  tabwriter.NewWriter(Writer, 0, 0, 2, ' ', 0),
+			columns: Columns,
+			header:  Header,
+		}, nil
+	}
+	err := errors.New("Unknown output format '" + Format + "', use one of " + strings.Join(OutputFormats, ", "))
+	logger.Error(err)
+	return nil, err
+}
+
+// NewRecord creates a record from a map, the fields are sorted by name.
+func NewRecord(Values map[string]interface{}) Record {
+	fields := make([]string, 0, len(Values))
+	for k := range Values {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	return Record{Fields: fields, Values: Values}
+}
+
+// Records converts the hits into records. Columns are paths into the
+// _source or metadata fields like _id, see ElasticsearchHitList.Field.
+// Without columns, a record holds the complete _source of the hit.
+func (result *ElasticsearchResult) Records(Columns []string) []Record {
+	records := make([]Record, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		if len(Columns) == 0 {
+			records = append(records, NewRecord(hit.Source))
+			continue
+		}
+		values := make(map[string]interface{}, len(Columns))
+		for _, c := range Columns {
+			values[c], _ = hit.Field(c)
+		}
+		records = append(records, Record{Fields: Columns, Values: values})
+	}
+	return records
+}
+
+// WriteRecords writes the hits to the formatter.
+func (result *ElasticsearchResult) WriteRecords(Output Formatter, Columns []string) error {
+	logger := log.WithField("func", "ElasticsearchResult.WriteRecords")
+	for _, record := range result.Records(Columns) {
+		err := Output.WriteRecord(record)
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+type jsonFormatter struct {
+	writer  io.Writer
+	columns []string
+	count   int
+}
+
+func (f *jsonFormatter) WriteRecord(record Record) error {
+	output, err := marshalRecord(record, f.columns)
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if f.count == 0 {
+		sep = "[\n  "
+	}
+	f.count++
+	_, err = io.WriteString(f.writer, sep+string(output))
+	return err
+}
+
+func (f *jsonFormatter) Close() error {
+	if f.count == 0 {
+		_, err := io.WriteString(f.writer, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(f.writer, "\n]\n")
+	return err
+}
+
+type ndjsonFormatter struct {
+	writer  io.Writer
+	columns []string
+}
+
+func (f *ndjsonFormatter) WriteRecord(record Record) error {
+	output, err := marshalRecord(record, f.columns)
+	if err != nil {
+		return err
+	}
+	_, err = f.writer.Write(append(output, '\n'))
+	return err
+}
+
+func (f *ndjsonFormatter) Close() error {
+	return nil
+}
+
+type yamlFormatter struct {
+	writer  io.Writer
+	columns []string
+}
+
+// WriteRecord writes the record as one item of a yaml sequence, so the
+// output of all records is a single list.
+func (f *yamlFormatter) WriteRecord(record Record) error {
+	item := &yaml.Node{Kind: yaml.MappingNode}
+	for _, field := range recordFields(record, f.columns) {
+		value := new(yaml.Node)
+		err := value.Encode(yamlValue(record.Values[field]))
+		if err != nil {
+			return err
+		}
+		item.Content = append(item.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: field}, value)
+	}
+	list := &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{item}}
+	encoder := yaml.NewEncoder(f.writer)
+	encoder.SetIndent(2)
+	err := encoder.Encode(list)
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func (f *yamlFormatter) Close() error {
+	return nil
+}
+
+// yamlValue converts integral numbers decoded from json back into integers,
+// so yaml does not write them in exponent notation, and writes json.Number
+// as a number instead of a string.
+func yamlValue(Value interface{}) interface{} {
+	switch v := Value.(type) {
+	case json.Number:
+		tag := "!!float"
+		if _, err := v.Int64(); err == nil {
+			tag = "!!int"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String()}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = yamlValue(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = yamlValue(e)
+		}
+		return l
+	}
+	return Value
+}
+
+type csvFormatter struct {
+	writer  *csv.Writer
+	columns []string
+	header  bool
+	started bool
+}
+
+func newCsvFormatter(Writer io.Writer, Comma rune, Columns []string, Header bool) *csvFormatter {
+	w := csv.NewWriter(Writer)
+	w.Comma = Comma
+	return &csvFormatter{writer: w, columns: Columns, header: Header}
+}
+
+func (f *csvFormatter) WriteRecord(record Record) error {
+	if !f.started {
+		f.started = true
+		if len(f.columns) == 0 {
+			f.columns = record.Fields
+		}
+		if f.header {
+			if err := f.writer.Write(f.columns); err != nil {
+				return err
+			}
+		}
+	}
+	row := make([]string, len(f.columns))
+	for i, c := range f.columns {
+		row[i] = FormatValue(record.Values[c])
+	}
+	return f.writer.Write(row)
+}
+
+func (f *csvFormatter) Close() error {
+	f.writer.Flush()
+	return f.writer.Error()
+}
+
+type tableFormatter struct {
+	writer  *tabwriter.Writer
+	columns []string
+	header  bool
+	started bool
+	// records are kept until Close without columns, to collect the
+	// fields of all records as columns.
+	records []Record
+}
+
+func (f *tableFormatter) WriteRecord(record Record) error {
+	if len(f.columns) == 0 {
+		f.records = append(f.records, record)
+		return nil
+	}
+	return f.writeRecord(record)
+}
+
+func (f *tableFormatter) writeRecord(record Record) error {
+	if !f.started {
+		f.started = true
+		if f.header {
+			if err := f.writeRow(f.columns); err != nil {
+				return err
+			}
+		}
+	}
+	row := make([]string, len(f.columns))
+	for i, c := range f.columns {
+		row[i] = FormatValue(record.Values[c])
+	}
+	return f.writeRow(row)
+}
+
+func (f *tableFormatter) writeRow(Row []string) error {
+	cells := make([]string, len(Row))
+	for i, cell := range Row {
+		cells[i] = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(cell)
+	}
+	_, err := fmt.Fprintln(f.writer, strings.Join(cells, "\t"))
+	return err
+}
+
+func (f *tableFormatter) Close() error {
+	if len(f.columns) == 0 {
+		seen := make(map[string]bool)
+		for _, record := range f.records {
+			for _, field := range record.Fields {
+				if !seen[field] {
+					seen[field] = true
+					f.columns = append(f.columns, field)
+				}
+			}
+		}
+		for _, record := range f.records {
+			if err := f.writeRecord(record); err != nil {
+				return err
+			}
+		}
+		f.records = nil
+	}
+	return f.writer.Flush()
+}
+
+// FormatValue converts a value into a string for column based output.
+// Objects and lists are written as json.
+func FormatValue(Value interface{}) string {
+	switch v := Value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool, int, int64, json.Number:
+		return fmt.Sprintf("%v", v)
+	}
+	output, err := json.Marshal(Value)
+	if err != nil {
+		return fmt.Sprintf("%v", Value)
+	}
+	return string(output)
+}
+
+// recordFields returns the columns to output for the record.
+func recordFields(Record Record, Columns []string) []string {
+	if len(Columns) > 0 {
+		return Columns
+	}
+	return Record.Fields
+}
+
+// marshalRecord encodes the record as json object keeping the field order.
+func marshalRecord(Record Record, Columns []string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range recordFields(Record, Columns) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(Record.Values[field])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
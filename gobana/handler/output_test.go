@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func testRecords() []Record {
+	return []Record{
+		NewRecord(map[string]interface{}{"host": "web1", "status": float64(200), "tags": []interface{}{"a", "b"}}),
+		NewRecord(map[string]interface{}{"host": "web\t2", "status": json.Number("9007199254740993"), "user": "bob, jr."}),
+	}
+}
+
+func TestFormatters(t *testing.T) {
+	tests := []struct {
+		format  string
+		columns []string
+		header  bool
+		want    string
+	}{
+		{OutputJson, nil, true, "[\n  {\"host\":\"web1\",\"status\":200,\"tags\":[\"a\",\"b\"]},\n  {\"host\":\"web\\t2\",\"status\":9007199254740993,\"user\":\"bob, jr.\"}\n]\n"},
+		{OutputJson, []string{"status"}, true, "[\n  {\"status\":200},\n  {\"status\":9007199254740993}\n]\n"},
+		{OutputNdjson, []string{"user", "host"}, true, "{\"user\":null,\"host\":\"web1\"}\n{\"user\":\"bob, jr.\",\"host\":\"web\\t2\"}\n"},
+		{OutputYaml, []string{"host", "status"}, true, "- host: web1\n  status: 200\n- host: \"web\\t2\"\n  status: 9007199254740993\n"},
+		{OutputCsv, nil, true, "host,status,tags\nweb1,200,\"[\"\"a\"\",\"\"b\"\"]\"\nweb\t2,9007199254740993,\n"},
+		{OutputCsv, []string{"user", "status"}, false, ",200\n\"bob, jr.\",9007199254740993\n"},
+		{OutputTsv, []string{"host", "user"}, true, "host\tuser\nweb1\t\n\"web\t2\"\tbob, jr.\n"},
+		{OutputTable, nil, true, "host   status            tags       user\nweb1   200               [\"a\",\"b\"]  \nweb 2  9007199254740993             bob, jr.\n"},
+		{OutputTable, []string{"status"}, false, "200\n9007199254740993\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		output, err := NewFormatter(test.format, &buf, test.columns, test.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range testRecords() {
+			if err := output.WriteRecord(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := output.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s %v:\n%s\nwant:\n%s", test.format, test.columns, buf.String(), test.want)
+		}
+	}
+}
+
+func TestFormattersEmpty(t *testing.T) {
+	for _, format := range OutputFormats {
+		var buf bytes.Buffer
+		output, err := NewFormatter(format, &buf, nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := output.Close(); err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if format == OutputJson {
+			want = "[]\n"
+		}
+		if buf.String() != want {
+			t.Errorf("%s without records = %q, want %q", format, buf.String(), want)
+		}
+	}
+	if _, err := NewFormatter("xml", &bytes.Buffer{}, nil, true); err == nil {
+		t.Error("NewFormatter(xml) succeeded, want error")
+	}
+}
@@ -0,0 +1,618 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultKeepAlive is used for point in time and scroll contexts when no
+// keep alive has been configured.
+const DefaultKeepAlive = "1m"
+
+// DefaultPageSize is used as page size when the query does not set "size".
+const DefaultPageSize = 1000
+
+// PageHandler is called by ExecuteAll for every page of hits. Pages of
+// parallel slices are passed to the handler one at a time.
+type PageHandler func(page *ElasticsearchResult) error
+
+// ExecuteAll pages through the complete result set of the query. It uses a
+// point in time and search_after and falls back to the scroll API if the
+// cluster has no point in time API (before 7.10) or, without a sort in the
+// query, can't sort by _shard_doc (7.10 and 7.11). The context is cleared when paging
+// ends, also when ctx is cancelled. If Slices is larger than one, the
+// result set is split into sliced requests running in parallel.
+func (gobana *Gobana) ExecuteAll(ctx context.Context, handle PageHandler) error {
+	logger := log.WithField("func", "Gobana.ExecuteAll")
+
+	if gobana.searchTemplate {
+		err := errors.New("Can't fetch all hits of a search template, use a query instead")
+		logger.Error(err)
+		return err
+	}
+	index, params, err := splitSearchEndpoint(gobana.Endpoint)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	body, err := queryBody(gobana.Query)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if _, ok := body["size"]; !ok {
+		body["size"] = DefaultPageSize
+	}
+	if from, ok := body["from"]; ok {
+		logger.WithField("from", from).Warn("Ignoring 'from' when fetching all hits")
+		delete(body, "from")
+	}
+	keepAlive := gobana.KeepAlive
+	if keepAlive == "" {
+		keepAlive = DefaultKeepAlive
+	}
+	slices := gobana.Slices
+	if slices < 1 {
+		slices = 1
+	}
+
+	start := time.Now()
+	merger := newPageMerger(handle, gobana.Ordered)
+	defer func() {
+		merger.close()
+	}()
+	scroll := func() error {
+		return runSlices(ctx, slices, func(ctx context.Context, slice int) error {
+			return gobana.scrollAll(ctx, index, params, sliceBody(body, slice, slices), keepAlive, merger.handler(slice))
+		})
+	}
+	pit, err := gobana.openPointInTime(ctx, index, keepAlive)
+	if err != nil && !pitUnsupported(err) {
+		logger.Error(err)
+		return err
+	}
+	if err != nil {
+		logger.WithField("error", err).Warn("Cluster has no point in time API, falling back to scroll")
+		err = scroll()
+	} else {
+		_, sorted := body["sort"]
+		if !sorted {
+			body["sort"] = []interface{}{map[string]interface{}{"_shard_doc": "asc"}}
+		}
+		latest := &pointInTime{id: pit}
+		err = runSlices(ctx, slices, func(ctx context.Context, slice int) error {
+			return gobana.searchAfterAll(ctx, latest, params, sliceBody(body, slice, slices), keepAlive, merger.handler(slice))
+		})
+		gobana.closePointInTime(latest.get())
+		if err != nil && !sorted && merger.total() == 0 && shardDocUnsupported(err) {
+			logger.WithField("error", err).Warn("Cluster can't sort by _shard_doc, falling back to scroll")
+			delete(body, "sort")
+			merger.close()
+			merger = newPageMerger(handle, gobana.Ordered)
+			err = scroll()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	hits := merger.total()
+	elapsed := time.Since(start)
+	logger.WithFields(log.Fields{
+		"slices":     slices,
+		"hits":       hits,
+		"duration":   elapsed.String(),
+		"hitsPerSec": throughput(hits, elapsed),
+	}).Info("Finished fetching all hits")
+	return nil
+}
+
+func (gobana *Gobana) searchAfterAll(ctx context.Context, pit *pointInTime, params string, body map[string]interface{}, keepAlive string, handle sliceHandler) error {
+	logger := log.WithField("func", "Gobana.searchAfterAll")
+
+	for {
+		if err := ctx.Err(); err != nil {
+			handle.interrupted()
+			return err
+		}
+		body["pit"] = map[string]interface{}{"id": pit.get(), "keep_alive": keepAlive}
+		page, err := gobana.search(ctx, "_search"+params, body)
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+		pit.set(page.PitId)
+		n := len(page.Hits.Hits)
+		if n == 0 {
+			break
+		}
+		if err := handle.page(page); err != nil {
+			logger.Error(err)
+			return err
+		}
+		last := page.Hits.Hits[n-1]
+		if len(last.Sort) == 0 {
+			err := errors.New("Hit without sort values, can't continue with search_after")
+			logger.Error(err)
+			return err
+		}
+		body["search_after"] = last.Sort
+	}
+	return handle.finish()
+}
+
+func (gobana *Gobana) scrollAll(ctx context.Context, index string, params string, body map[string]interface{}, keepAlive string, handle sliceHandler) error {
+	logger := log.WithField("func", "Gobana.scrollAll")
+
+	endpoint := "_search?scroll=" + keepAlive
+	if index != "" {
+		endpoint = index + "/" + endpoint
+	}
+	if params != "" {
+		endpoint += "&" + strings.TrimPrefix(params, "?")
+	}
+	var scrollId string
+	defer func() {
+		gobana.clearScroll(scrollId)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	page, err := gobana.search(ctx, endpoint, body)
+	for {
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+		if page.ScrollId != "" {
+			scrollId = page.ScrollId
+		}
+		if len(page.Hits.Hits) == 0 {
+			break
+		}
+		if err := handle.page(page); err != nil {
+			logger.Error(err)
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			handle.interrupted()
+			return err
+		}
+		page, err = gobana.search(ctx, "_search/scroll", map[string]interface{}{
+			"scroll":    keepAlive,
+			"scroll_id": scrollId,
+		})
+	}
+	return handle.finish()
+}
+
+// runSlices runs fn for every slice in its own goroutine. The first error
+// cancels the remaining slices.
+func runSlices(ctx context.Context, slices int, fn func(ctx context.Context, slice int) error) error {
+	if slices == 1 {
+		return fn(ctx, 0)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	for i := 0; i < slices; i++ {
+		wg.Add(1)
+		go func(slice int) {
+			defer wg.Done()
+			if err := fn(ctx, slice); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	return first
+}
+
+// sliceBody returns a copy of the body restricted to one slice.
+func sliceBody(Body map[string]interface{}, Slice int, Max int) map[string]interface{} {
+	body := make(map[string]interface{}, len(Body)+1)
+	for k, v := range Body {
+		body[k] = v
+	}
+	if Max > 1 {
+		body["slice"] = map[string]interface{}{"id": Slice, "max": Max}
+	}
+	return body
+}
+
+// pointInTime holds the most recent point in time id shared by all slices.
+type pointInTime struct {
+	mu sync.Mutex
+	id string
+}
+
+func (pit *pointInTime) get() string {
+	pit.mu.Lock()
+	defer pit.mu.Unlock()
+	return pit.id
+}
+
+func (pit *pointInTime) set(Id string) {
+	if Id == "" {
+		return
+	}
+	pit.mu.Lock()
+	defer pit.mu.Unlock()
+	pit.id = Id
+}
+
+// pageMerger serializes the pages of all slices into one PageHandler. When
+// ordered, the pages of a slice are passed on once all previous slices are
+// finished. Until then they are written to a pageSpool, so all slices keep
+// fetching without holding their pages in memory or letting their point in
+// time or scroll contexts expire.
+type pageMerger struct {
+	mu      sync.Mutex
+	handle  PageHandler
+	ordered bool
+	current int
+	done    map[int]bool
+	spools  map[int]*pageSpool
+	hits    int
+}
+
+func newPageMerger(handle PageHandler, ordered bool) *pageMerger {
+	return &pageMerger{
+		handle:  handle,
+		ordered: ordered,
+		done:    make(map[int]bool),
+		spools:  make(map[int]*pageSpool),
+	}
+}
+
+func (merger *pageMerger) handler(Slice int) sliceHandler {
+	return sliceHandler{
+		merger: merger,
+		slice:  Slice,
+		start:  time.Now(),
+	}
+}
+
+// add passes the page of the slice to the handler. When ordered and it is
+// not the turn of the slice yet, the page is spooled instead.
+func (merger *pageMerger) add(Slice int, Page *ElasticsearchResult) error {
+	merger.mu.Lock()
+	defer merger.mu.Unlock()
+	if merger.ordered && Slice != merger.current {
+		spool := merger.spools[Slice]
+		if spool == nil {
+			var err error
+			spool, err = newPageSpool()
+			if err != nil {
+				return err
+			}
+			merger.spools[Slice] = spool
+		}
+		return spool.write(Page)
+	}
+	return merger.pass(Page)
+}
+
+func (merger *pageMerger) pass(Page *ElasticsearchResult) error {
+	merger.hits += len(Page.Hits.Hits)
+	return merger.handle(Page)
+}
+
+// finish marks the slice as finished. If it was the turn of the slice, the
+// turn moves on to the next slices, passing their spooled pages, until one
+// is not finished yet.
+func (merger *pageMerger) finish(Slice int) error {
+	merger.mu.Lock()
+	defer merger.mu.Unlock()
+	merger.done[Slice] = true
+	for merger.done[merger.current] {
+		merger.current++
+		spool := merger.spools[merger.current]
+		if spool == nil {
+			continue
+		}
+		delete(merger.spools, merger.current)
+		if err := spool.drain(merger.pass); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close removes the spools of slices which did not get their turn because
+// paging failed.
+func (merger *pageMerger) close() {
+	merger.mu.Lock()
+	defer merger.mu.Unlock()
+	for slice, spool := range merger.spools {
+		spool.remove()
+		delete(merger.spools, slice)
+	}
+}
+
+func (merger *pageMerger) total() int {
+	merger.mu.Lock()
+	defer merger.mu.Unlock()
+	return merger.hits
+}
+
+// sliceHandler passes the pages of one slice to the merger and logs the
+// progress of the slice.
+type sliceHandler struct {
+	merger *pageMerger
+	slice  int
+	start  time.Time
+	pages  int
+	hits   int
+}
+
+func (handler *sliceHandler) page(Page *ElasticsearchResult) error {
+	handler.pages++
+	handler.hits += len(Page.Hits.Hits)
+	handler.logger().Debug("Received page")
+	return handler.merger.add(handler.slice, Page)
+}
+
+func (handler *sliceHandler) finish() error {
+	handler.logger().Info("Finished slice")
+	return handler.merger.finish(handler.slice)
+}
+
+func (handler *sliceHandler) interrupted() {
+	handler.logger().Warn("Paging interrupted")
+}
+
+func (handler *sliceHandler) logger() *log.Entry {
+	return log.WithFields(log.Fields{
+		"func":       "sliceHandler",
+		"slice":      handler.slice,
+		"pages":      handler.pages,
+		"hits":       handler.hits,
+		"hitsPerSec": throughput(handler.hits, time.Since(handler.start)),
+	})
+}
+
+// pageSpool keeps the pages of a slice waiting for its turn in a
+// temporary file, one json document per page.
+type pageSpool struct {
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// spooledPage is a page in the spool. Sources holds the undecoded _source
+// of the hits, which is not part of their json.
+type spooledPage struct {
+	Page    *ElasticsearchResult
+	Sources []json.RawMessage
+}
+
+func newPageSpool() (*pageSpool, error) {
+	file, err := os.CreateTemp("", "gobana-slice-*.json")
+	if err != nil {
+		log.WithField("func", "newPageSpool").Error(err)
+		return nil, err
+	}
+	return &pageSpool{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (spool *pageSpool) write(Page *ElasticsearchResult) error {
+	sources := make([]json.RawMessage, len(Page.Hits.Hits))
+	for i, hit := range Page.Hits.Hits {
+		sources[i] = hit.RawSource
+	}
+	return spool.encoder.Encode(spooledPage{Page: Page, Sources: sources})
+}
+
+// drain passes the spooled pages to handle in the order they were written
+// and removes the spool.
+func (spool *pageSpool) drain(handle PageHandler) error {
+	logger := log.WithField("func", "pageSpool.drain")
+	defer spool.remove()
+
+	if _, err := spool.file.Seek(0, io.SeekStart); err != nil {
+		logger.Error(err)
+		return err
+	}
+	decoder := json.NewDecoder(bufio.NewReader(spool.file))
+	decoder.UseNumber()
+	for {
+		var spooled spooledPage
+		err := decoder.Decode(&spooled)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+		for i, source := range spooled.Sources {
+			if i < len(spooled.Page.Hits.Hits) && !bytes.Equal(source, []byte("null")) {
+				spooled.Page.Hits.Hits[i].RawSource = source
+			}
+		}
+		if err := handle(spooled.Page); err != nil {
+			return err
+		}
+	}
+}
+
+func (spool *pageSpool) remove() {
+	spool.file.Close()
+	os.Remove(spool.file.Name())
+}
+
+func throughput(Hits int, Elapsed time.Duration) float64 {
+	if Elapsed <= 0 {
+		return 0
+	}
+	return float64(int(float64(Hits)/Elapsed.Seconds()*10)) / 10
+}
+
+// search posts the body to the endpoint and decodes the result.
+func (gobana *Gobana) search(ctx context.Context, Endpoint string, Body map[string]interface{}) (*ElasticsearchResult, error) {
+	logger := log.WithFields(log.Fields{"func": "Gobana.search", "endpoint": Endpoint})
+
+	query, err := json.Marshal(Body)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	logger.WithField("query", string(query)).Debug("Search")
+	result, err := gobana.send(ctx, http.MethodPost, Endpoint, query)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return result, nil
+}
+
+func (gobana *Gobana) openPointInTime(ctx context.Context, Index string, KeepAlive string) (string, error) {
+	logger := log.WithField("func", "Gobana.openPointInTime")
+
+	if Index == "" {
+		Index = "_all"
+	}
+	response, err := Request(ctx, gobana.Connection, http.MethodPost, "/"+Index+"/_pit?keep_alive="+KeepAlive, nil)
+	if err != nil {
+		return "", err
+	}
+	var pit struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(response.Body, &pit)
+	if err != nil {
+		return "", err
+	}
+	if pit.Id == "" {
+		return "", errors.New("Response did not contain a point in time id")
+	}
+	logger.WithField("index", Index).Debug("Opened point in time")
+	return pit.Id, nil
+}
+
+// pitUnsupported returns true if opening a point in time failed because
+// the cluster has no point in time API. Older clusters answer with 405, 404
+// or 400 (no handler, or _pit taken as type name), a missing index, missing
+// permissions or connection failures are real errors.
+func pitUnsupported(Err error) bool {
+	var requestError *RequestError
+	if !errors.As(Err, &requestError) {
+		return false
+	}
+	errorType, reason := "", ""
+	if requestError.Err != nil {
+		errorType, reason = requestError.Err.Type, requestError.Err.Reason
+	}
+	switch requestError.Status {
+	case http.StatusMethodNotAllowed:
+		return true
+	case http.StatusNotFound:
+		return errorType != "index_not_found_exception"
+	case http.StatusBadRequest:
+		return errorType == "invalid_type_name_exception" || strings.Contains(reason, "no handler found")
+	}
+	return false
+}
+
+// shardDocUnsupported returns true if a search failed because the cluster
+// can't sort by _shard_doc. 7.10 and 7.11 have a point in time API but
+// take _shard_doc for an unmapped field. Sorting by _doc instead would not
+// be unique across shards, so the caller falls back to scroll.
+func shardDocUnsupported(Err error) bool {
+	var requestError *RequestError
+	if !errors.As(Err, &requestError) || requestError.Status != http.StatusBadRequest {
+		return false
+	}
+	return requestError.Err.mentions("_shard_doc")
+}
+
+func (gobana *Gobana) closePointInTime(Id string) {
+	logger := log.WithField("func", "Gobana.closePointInTime")
+
+	body, _ := json.Marshal(map[string]interface{}{"id": Id})
+	_, err := gobana.Connection.Delete("/_pit", body)
+	if err != nil {
+		logger.WithField("error", err).Warn("Could not close point in time")
+		return
+	}
+	logger.Debug("Closed point in time")
+}
+
+func (gobana *Gobana) clearScroll(Id string) {
+	logger := log.WithField("func", "Gobana.clearScroll")
+
+	if Id == "" {
+		return
+	}
+	body, _ := json.Marshal(map[string]interface{}{"scroll_id": []string{Id}})
+	_, err := gobana.Connection.Delete("/_search/scroll", body)
+	if err != nil {
+		logger.WithField("error", err).Warn("Could not clear scroll")
+		return
+	}
+	logger.Debug("Cleared scroll")
+}
+
+// splitSearchEndpoint splits "index/_search?params" into the index part and
+// the url parameters (including the leading "?").
+func splitSearchEndpoint(Endpoint string) (string, string, error) {
+	path := strings.TrimPrefix(Endpoint, "/")
+	params := ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, params = path[:i], path[i:]
+	}
+	path = strings.TrimSuffix(path, "/")
+	if path == "_search" {
+		return "", params, nil
+	}
+	if strings.HasSuffix(path, "/_search") {
+		return strings.TrimSuffix(path, "/_search"), params, nil
+	}
+	return "", "", errors.New("Fetching all hits requires a _search endpoint, got '" + Endpoint + "'")
+}
+
+// queryBody decodes the query into a map which can be extended. Numbers
+// are kept as json.Number, so large integers survive encoding the query
+// again.
+func queryBody(Query string) (map[string]interface{}, error) {
+	body := make(map[string]interface{})
+	if strings.TrimSpace(Query) == "" {
+		return body, nil
+	}
+	err := decodeNumbers([]byte(Query), &body)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// decodeNumbers works like json.Unmarshal but decodes numbers into
+// json.Number instead of float64, which can't hold integers above 2^53.
+func decodeNumbers(Data []byte, Value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(Data))
+	decoder.UseNumber()
+	if err := decoder.Decode(Value); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid json: unexpected data after the top-level value")
+	}
+	return nil
+}
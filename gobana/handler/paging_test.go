@@ -0,0 +1,403 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joernott/lra"
+)
+
+// fakeCluster is an Elasticsearch stand-in serving Docs sorted by a large
+// integer sort value, with point in time and scroll support.
+type fakeCluster struct {
+	Docs       int
+	PitStatus  int
+	PitBody    string
+	NoShardDoc bool
+
+	mu       sync.Mutex
+	requests []string
+	after    []string
+	scrolls  map[string]int
+}
+
+func (c *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, r.Method+" "+r.URL.Path)
+	var body map[string]interface{}
+	if len(data) > 0 {
+		decodeNumbers(data, &body)
+	}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/_pit") && r.Method == http.MethodPost:
+		if c.PitStatus != 0 {
+			w.WriteHeader(c.PitStatus)
+			w.Write([]byte(c.PitBody))
+			return
+		}
+		w.Write([]byte(`{"id":"pit-1"}`))
+	case r.URL.Path == "/_pit" || r.URL.Path == "/_search/scroll" && r.Method == http.MethodDelete:
+		w.Write([]byte(`{"succeeded":true}`))
+	case r.URL.Path == "/_search/scroll":
+		id := body["scroll_id"].(string)
+		c.writePage(w, c.scrolls[id], 2, id)
+		c.scrolls[id] += 2
+	case r.URL.Query().Get("scroll") != "":
+		if c.scrolls == nil {
+			c.scrolls = make(map[string]int)
+		}
+		size, _ := body["size"].(json.Number).Int64()
+		c.scrolls["scroll-1"] = int(size)
+		c.writePage(w, 0, int(size), "scroll-1")
+	case c.NoShardDoc && strings.Contains(string(data), "_shard_doc"):
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(shardDocError))
+	default:
+		start := 0
+		if after, ok := body["search_after"].([]interface{}); ok {
+			c.after = append(c.after, after[0].(json.Number).String())
+			n, _ := strconv.ParseInt(after[0].(json.Number).String(), 10, 64)
+			start = int(n-fakeSortBase) + 1
+		}
+		size, _ := body["size"].(json.Number).Int64()
+		c.writePage(w, start, int(size), "")
+	}
+}
+
+const fakeSortBase = 9007199254740990
+
+// shardDocError is the answer of 7.10 and 7.11 to a sort by _shard_doc.
+const shardDocError = `{"error":{"root_cause":[{"type":"query_shard_exception","reason":"No mapping found for [_shard_doc] in order to sort on","index":"logs"}],"type":"search_phase_execution_exception","reason":"all shards failed","phase":"query","grouped":true,"failed_shards":[{"shard":0,"index":"logs","node":"n1","reason":{"type":"query_shard_exception","reason":"No mapping found for [_shard_doc] in order to sort on","index":"logs"}}]},"status":400}`
+
+func (c *fakeCluster) writePage(w http.ResponseWriter, Start int, Size int, ScrollId string) {
+	var hits []string
+	for i := Start; i < c.Docs && i < Start+Size; i++ {
+		hits = append(hits, `{"_index":"logs","_id":"`+strconv.Itoa(i)+`","_source":{"n":`+strconv.Itoa(i)+`},"sort":[`+strconv.FormatInt(fakeSortBase+int64(i), 10)+`]}`)
+	}
+	extra := `"pit_id":"pit-1"`
+	if ScrollId != "" {
+		extra = `"_scroll_id":"` + ScrollId + `"`
+	}
+	w.Write([]byte(`{"took":1,` + extra + `,"hits":{"total":{"value":` + strconv.Itoa(c.Docs) + `,"relation":"eq"},"hits":[` + strings.Join(hits, ",") + `]}}`))
+}
+
+func (c *fakeCluster) has(Request string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.requests {
+		if r == Request {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestGobana(t *testing.T, Handler http.Handler, Query string) *Gobana {
+	t.Helper()
+	server := httptest.NewServer(Handler)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	connection, err := lra.NewConnection(false, u.Hostname(), port, "", "", "", false, "", false, lra.HeaderList{"Content-Type": "application/json"}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Gobana{Connection: connection, Endpoint: "logs/_search", Query: Query}
+}
+
+func collectIds(t *testing.T, g *Gobana) ([]string, error) {
+	var ids []string
+	err := g.ExecuteAll(context.Background(), func(page *ElasticsearchResult) error {
+		for _, hit := range page.Hits.Hits {
+			ids = append(ids, hit.Id)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func TestExecuteAllPointInTime(t *testing.T) {
+	cluster := &fakeCluster{Docs: 5}
+	ids, err := collectIds(t, newTestGobana(t, cluster, `{"size":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "0,1,2,3,4" {
+		t.Errorf("ids = %v, want 0 to 4", ids)
+	}
+	if got := strings.Join(cluster.after, ","); got != "9007199254740991,9007199254740993,9007199254740994" {
+		t.Errorf("search_after = %s, want the exact sort values", got)
+	}
+	if !cluster.has("DELETE /_pit") {
+		t.Error("point in time was not closed")
+	}
+}
+
+func TestExecuteAllScrollFallback(t *testing.T) {
+	cluster := &fakeCluster{Docs: 5, PitStatus: http.StatusMethodNotAllowed,
+		PitBody: `{"error":"Incorrect HTTP method for uri [/logs/_pit]","status":405}`}
+	ids, err := collectIds(t, newTestGobana(t, cluster, `{"size":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "0,1,2,3,4" {
+		t.Errorf("ids = %v, want 0 to 4", ids)
+	}
+	if !cluster.has("DELETE /_search/scroll") {
+		t.Error("scroll was not cleared")
+	}
+}
+
+func TestExecuteAllShardDocFallback(t *testing.T) {
+	cluster := &fakeCluster{Docs: 5, NoShardDoc: true}
+	ids, err := collectIds(t, newTestGobana(t, cluster, `{"size":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "0,1,2,3,4" {
+		t.Errorf("ids = %v, want 0 to 4", ids)
+	}
+	if !cluster.has("DELETE /_pit") || !cluster.has("DELETE /_search/scroll") {
+		t.Error("point in time not closed or scroll not cleared")
+	}
+
+	// A sort given in the query is not replaced.
+	cluster = &fakeCluster{Docs: 5, NoShardDoc: true}
+	if _, err := collectIds(t, newTestGobana(t, cluster, `{"size":2,"sort":["_shard_doc"]}`)); KindOf(err) != ErrorQueryParse {
+		t.Errorf("ExecuteAll with _shard_doc in the query = %v, want query parse error", err)
+	}
+}
+
+func TestShardDocUnsupported(t *testing.T) {
+	if !shardDocUnsupported(NewRequestError([]byte(shardDocError), errors.New("400 Bad Request"))) {
+		t.Error("shardDocUnsupported = false for the 7.10 answer")
+	}
+	if shardDocUnsupported(&RequestError{Status: 400, Err: &ElasticsearchError{Type: "parsing_exception", Reason: "unknown field [sizee]"}}) {
+		t.Error("shardDocUnsupported = true for a parse error")
+	}
+}
+
+func TestExecuteAllPointInTimeError(t *testing.T) {
+	cluster := &fakeCluster{Docs: 5, PitStatus: http.StatusForbidden,
+		PitBody: `{"error":{"type":"security_exception","reason":"action [indices:data/read/open_point_in_time] is unauthorized"},"status":403}`}
+	_, err := collectIds(t, newTestGobana(t, cluster, `{"size":2}`))
+	if KindOf(err) != ErrorAuthentication {
+		t.Errorf("ExecuteAll = %v, want authentication error", err)
+	}
+	if cluster.has("POST /logs/_search") {
+		t.Error("fell back to scroll after a permission error")
+	}
+}
+
+func TestPitUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"method not allowed", &RequestError{Status: 405}, true},
+		{"no handler", &RequestError{Status: 400, Err: &ElasticsearchError{Reason: "no handler found for uri [/logs/_pit]"}}, true},
+		{"type name", &RequestError{Status: 400, Err: &ElasticsearchError{Type: "invalid_type_name_exception"}}, true},
+		{"not found", &RequestError{Status: 404}, true},
+		{"index not found", &RequestError{Status: 404, Err: &ElasticsearchError{Type: "index_not_found_exception"}}, false},
+		{"unauthorized", &RequestError{Status: 401}, false},
+		{"forbidden", &RequestError{Status: 403}, false},
+		{"bad request", &RequestError{Status: 400, Err: &ElasticsearchError{Type: "parsing_exception"}}, false},
+		{"connection", errors.New("connection refused"), false},
+	}
+	for _, test := range tests {
+		if got := pitUnsupported(test.err); got != test.want {
+			t.Errorf("%s: pitUnsupported = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSliceBody(t *testing.T) {
+	body := map[string]interface{}{"size": 10}
+	got, _ := json.Marshal(sliceBody(body, 1, 3))
+	if string(got) != `{"size":10,"slice":{"id":1,"max":3}}` {
+		t.Errorf("sliceBody = %s", got)
+	}
+	if _, ok := body["slice"]; ok {
+		t.Error("sliceBody changed the body")
+	}
+	got, _ = json.Marshal(sliceBody(body, 0, 1))
+	if string(got) != `{"size":10}` {
+		t.Errorf("sliceBody without slices = %s", got)
+	}
+}
+
+func TestLargeNumbers(t *testing.T) {
+	query := `{"query":{"term":{"id":9007199254740993}},"size":10}`
+	body, err := queryBody(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(body)
+	if string(data) != query {
+		t.Errorf("queryBody round trip = %s, want %s", data, query)
+	}
+	if _, err := queryBody(`{"size":1} x`); err == nil {
+		t.Error("queryBody with trailing data succeeded, want error")
+	}
+
+	var hit ElasticsearchHitList
+	err = json.Unmarshal([]byte(`{"_id":"1","_source":{},"sort":[9007199254740993,"web1"]}`), &hit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = json.Marshal(hit.Sort)
+	if string(data) != `[9007199254740993,"web1"]` {
+		t.Errorf("sort values = %s, want [9007199254740993,\"web1\"]", data)
+	}
+}
+
+func slicePage(Slice int, Page int) *ElasticsearchResult {
+	id := strconv.Itoa(Slice) + "-" + strconv.Itoa(Page)
+	return &ElasticsearchResult{Hits: ElasticsearchHitResult{Hits: []ElasticsearchHitList{
+		{Id: id, RawSource: json.RawMessage(`{"id":"` + id + `","n":9007199254740993}`), Sort: []interface{}{json.Number("9007199254740993")}},
+	}}}
+}
+
+func TestPageMergerOrdered(t *testing.T) {
+	var ids, sources []string
+	merger := newPageMerger(func(page *ElasticsearchResult) error {
+		hit := page.Hits.Hits[0]
+		ids = append(ids, hit.Id)
+		sort, _ := json.Marshal(hit.Sort)
+		sources = append(sources, string(hit.RawSource)+string(sort))
+		return nil
+	}, true)
+	defer merger.close()
+
+	// Slice 2 finishes while slice 0 is still fetching, slice 1 has no
+	// hits.
+	slow := make(chan struct{})
+	fast := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- runSlices(context.Background(), 3, func(ctx context.Context, slice int) error {
+			handle := merger.handler(slice)
+			if slice == 0 {
+				<-slow
+			}
+			if slice != 1 {
+				for page := 0; page < 2; page++ {
+					if err := handle.page(slicePage(slice, page)); err != nil {
+						return err
+					}
+				}
+			}
+			if err := handle.finish(); err != nil {
+				return err
+			}
+			if slice == 2 {
+				close(fast)
+			}
+			return nil
+		})
+	}()
+	select {
+	case <-fast:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slice 2 waited for slice 0")
+	}
+	merger.mu.Lock()
+	early := len(ids)
+	merger.mu.Unlock()
+	if early != 0 {
+		t.Errorf("%d pages handled before slice 0, want slice 2 to be spooled", early)
+	}
+	close(slow)
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("merging did not finish")
+	}
+	if got := strings.Join(ids, ","); got != "0-0,0-1,2-0,2-1" {
+		t.Errorf("pages = %s, want slice by slice", got)
+	}
+	if want := `{"id":"2-1","n":9007199254740993}[9007199254740993]`; sources[3] != want {
+		t.Errorf("spooled hit = %s, want %s", sources[3], want)
+	}
+	if merger.total() != 4 {
+		t.Errorf("total = %d, want 4", merger.total())
+	}
+	if len(merger.spools) != 0 {
+		t.Errorf("%d spools left", len(merger.spools))
+	}
+}
+
+func TestPageMergerUnordered(t *testing.T) {
+	count := 0
+	merger := newPageMerger(func(page *ElasticsearchResult) error {
+		count++
+		return nil
+	}, false)
+	err := runSlices(context.Background(), 4, func(ctx context.Context, slice int) error {
+		handle := merger.handler(slice)
+		for page := 0; page < 3; page++ {
+			if err := handle.page(slicePage(slice, page)); err != nil {
+				return err
+			}
+		}
+		return handle.finish()
+	})
+	if err != nil || count != 12 || merger.total() != 12 {
+		t.Errorf("runSlices = %v with %d pages (total %d), want 12", err, count, merger.total())
+	}
+}
+
+func TestPageMergerError(t *testing.T) {
+	failed := errors.New("disk full")
+	for _, ordered := range []bool{true, false} {
+		merger := newPageMerger(func(page *ElasticsearchResult) error {
+			if page.Hits.Hits[0].Id == "0-1" {
+				return failed
+			}
+			return nil
+		}, ordered)
+		defer merger.close()
+		errs := make(chan error, 1)
+		go func() {
+			errs <- runSlices(context.Background(), 3, func(ctx context.Context, slice int) error {
+				handle := merger.handler(slice)
+				for page := 0; ; page++ {
+					if err := handle.page(slicePage(slice, page)); err != nil {
+						return err
+					}
+					if slice != 0 && page > 100 {
+						<-ctx.Done()
+						return ctx.Err()
+					}
+				}
+			})
+		}()
+		select {
+		case err := <-errs:
+			if err != failed {
+				t.Errorf("ordered %v: runSlices = %v, want %v", ordered, err, failed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ordered %v: error did not stop the slices", ordered)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Policies for partial results, i.e. results with failed shards or which
+// timed out.
+const (
+	PartialWarn   = "warn"
+	PartialFail   = "fail"
+	PartialIgnore = "ignore"
+)
+
+// PartialPolicies lists all policies for partial results.
+var PartialPolicies = []string{PartialWarn, PartialFail, PartialIgnore}
+
+// PartialResultError is returned for partial results when the policy is
+// PartialFail.
+type PartialResultError struct {
+	TimedOut bool
+	Shards   ElasticsearchShardResult
+}
+
+// CheckPartial returns a PartialResultError if shards failed or the search
+// timed out, nil if the result is complete.
+func (result *ElasticsearchResult) CheckPartial() *PartialResultError {
+	if !result.TimedOut && result.Shards.Failed == 0 && len(result.Shards.Failures) == 0 {
+		return nil
+	}
+	return &PartialResultError{TimedOut: result.TimedOut, Shards: result.Shards}
+}
+
+// ValidatePartialPolicy returns an error for unknown policies.
+func ValidatePartialPolicy(Policy string) error {
+	for _, p := range PartialPolicies {
+		if Policy == p {
+			return nil
+		}
+	}
+	return errors.New("Unknown policy for partial results '" + Policy + "', use one of " + strings.Join(PartialPolicies, ", "))
+}
+
+// checkPartial applies the policy for partial results of the Gobana. With
+// PartialWarn (the default), failed shards are logged and reported to the
+// Warnings writer.
+func (gobana *Gobana) checkPartial(Result *ElasticsearchResult) error {
+	logger := log.WithField("func", "Gobana.checkPartial")
+
+	partial := Result.CheckPartial()
+	if partial == nil {
+		return nil
+	}
+	switch gobana.OnPartial {
+	case PartialIgnore:
+		logger.WithField("error", partial).Debug("Ignoring partial result")
+		return nil
+	case PartialFail:
+		logger.Error(partial)
+		return partial
+	}
+	for _, failure := range partial.Shards.Failures {
+		logger.WithFields(log.Fields{
+			"shard":  failure.Shard,
+			"index":  failure.Index,
+			"node":   failure.Node,
+			"reason": failure.Reason.String(),
+		}).Warn("Shard failed")
+	}
+	logger.Warn(partial)
+	if gobana.Warnings != nil {
+		fmt.Fprint(gobana.Warnings, partial.Describe())
+	}
+	return nil
+}
+
+func (e *PartialResultError) Error() string {
+	var parts []string
+	if e.Shards.Failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d of %d shards failed", e.Shards.Failed, e.Shards.Total))
+	}
+	if e.TimedOut {
+		parts = append(parts, "search timed out")
+	}
+	return "Partial result: " + strings.Join(parts, ", ")
+}
+
+// Kind returns ErrorTimeout if the search only timed out and
+// ErrorPartialShards if shards failed.
+func (e *PartialResultError) Kind() ErrorKind {
+	if e.Shards.Failed == 0 && len(e.Shards.Failures) == 0 {
+		return ErrorTimeout
+	}
+	return ErrorPartialShards
+}
+
+// Describe returns a readable description of the failed shards.
+func (e *PartialResultError) Describe() string {
+	var b strings.Builder
+	b.WriteString(e.Error() + "\n")
+	for _, failure := range e.Shards.Failures {
+		failure.describe(&b, "  ")
+	}
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPartial(t *testing.T) {
+	tests := []struct {
+		file     string
+		partial  bool
+		kind     ErrorKind
+		failures int
+	}{
+		{"es8_search.json", false, ErrorUnknown, 0},
+		{"opensearch_search.json", true, ErrorPartialShards, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			partial := readResult(t, test.file).CheckPartial()
+			if (partial != nil) != test.partial {
+				t.Fatalf("CheckPartial = %v, want partial %v", partial, test.partial)
+			}
+			if partial == nil {
+				return
+			}
+			if partial.Kind() != test.kind {
+				t.Errorf("Kind = %v, want %v", partial.Kind(), test.kind)
+			}
+			if len(partial.Shards.Failures) != test.failures {
+				t.Errorf("len(Failures) = %d, want %d", len(partial.Shards.Failures), test.failures)
+			}
+			if !strings.Contains(partial.Describe(), "No mapping found for [timestamp]") {
+				t.Errorf("Describe does not contain the shard failure: %s", partial.Describe())
+			}
+		})
+	}
+}
+
+func TestPartialPolicy(t *testing.T) {
+	result := readResult(t, "opensearch_search.json")
+	for policy, fails := range map[string]bool{PartialIgnore: false, PartialFail: true} {
+		g := &Gobana{OnPartial: policy}
+		if err := g.checkPartial(result); (err != nil) != fails {
+			t.Errorf("checkPartial with %s = %v, want error %v", policy, err, fails)
+		}
+	}
+	if err := ValidatePartialPolicy("sometimes"); err == nil {
+		t.Error("ValidatePartialPolicy accepted an unknown policy")
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/joernott/lra"
+	log "github.com/sirupsen/logrus"
+)
+
+// Methods lists the HTTP methods supported by Request.
+var Methods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+// Response is the response to a request. Status is the HTTP status of a
+// failed request as reported in the body by Elasticsearch. The connection
+// does not report the status, so it is 0 for successful requests and
+// failed ones without a status in the body.
+type Response struct {
+	Status int
+	Body   []byte
+}
+
+// Request sends a request with the method (GET, HEAD, POST, PUT or DELETE)
+// to the path. GET requests with a body are sent as POST, which
+// Elasticsearch accepts for all of them. Failed requests return the
+// response, if there is one, and a *RequestError. If ctx is cancelled
+// before the response arrives, ctx.Err() is returned.
+func Request(ctx context.Context, Connection *lra.Connection, Method string, Path string, Body []byte) (*Response, error) {
+	logger := log.WithFields(log.Fields{"func": "Request", "method": Method, "path": Path})
+
+	if !strings.HasPrefix(Path, "/") {
+		Path = "/" + Path
+	}
+	var send func() ([]byte, error)
+	switch strings.ToUpper(Method) {
+	case http.MethodGet:
+		if len(Body) > 0 {
+			logger.Debug("Sending GET request with body as POST")
+			send = func() ([]byte, error) { return Connection.Post(Path, Body) }
+		} else {
+			send = func() ([]byte, error) { return Connection.Get(Path) }
+		}
+	case http.MethodHead:
+		send = func() ([]byte, error) { return Connection.Head(Path) }
+	case http.MethodPost:
+		send = func() ([]byte, error) { return Connection.Post(Path, Body) }
+	case http.MethodPut:
+		send = func() ([]byte, error) { return Connection.Put(Path, Body) }
+	case http.MethodDelete:
+		send = func() ([]byte, error) { return Connection.Delete(Path, Body) }
+	default:
+		err := errors.New("Unsupported method '" + Method + "', use one of " + strings.Join(Methods, ", "))
+		logger.Error(err)
+		return nil, err
+	}
+	logger.WithField("body", string(Body)).Debug("Request")
+	data, err := withContext(ctx, send)
+	if err != nil {
+		if err == ctx.Err() {
+			return nil, err
+		}
+		requestError := NewRequestError(data, err)
+		logger.Debug(requestError)
+		if requestError.Status == 0 && len(data) == 0 {
+			return nil, requestError
+		}
+		return &Response{Status: requestError.Status, Body: data}, requestError
+	}
+	return &Response{Body: data}, nil
+}
+
+// isMethod returns true for the methods supported by Request.
+func isMethod(Method string) bool {
+	for _, m := range Methods {
+		if strings.EqualFold(m, Method) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddParam adds the url parameter to the path, which may already contain
+// parameters.
+func AddParam(Path string, Name string, Value string) string {
+	separator := "?"
+	if strings.Contains(Path, "?") {
+		separator = "&"
+	}
+	return Path + separator + url.QueryEscape(Name) + "=" + url.QueryEscape(Value)
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// echoRequest answers with the method, path and body of the request, or
+// with the status in the path like /status/404.
+func echoRequest(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if status, ok := strings.CutPrefix(r.URL.Path, "/status/"); ok {
+		switch status {
+		case "404":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"type":"index_not_found_exception","reason":"no such index [logs]"},"status":404}`))
+		case "text":
+			w.Write([]byte("green open logs-1\n"))
+		}
+		return
+	}
+	w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(body)))
+}
+
+func TestRequest(t *testing.T) {
+	connection := newTestGobana(t, http.HandlerFunc(echoRequest), "").Connection
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		want   string
+	}{
+		{"GET", "/_cluster/health", "", "GET /_cluster/health "},
+		{"get", "_cat/indices?v", "", "GET /_cat/indices?v "},
+		{"GET", "/logs/_search", `{"size":1}`, `POST /logs/_search {"size":1}`},
+		{"POST", "/logs/_refresh", "", "POST /logs/_refresh "},
+		{"PUT", "/logs", `{"settings":{}}`, `PUT /logs {"settings":{}}`},
+		{"DELETE", "/logs", "", "DELETE /logs "},
+		{"HEAD", "/logs", "", ""},
+	}
+	for _, test := range tests {
+		response, err := Request(context.Background(), connection, test.method, test.path, []byte(test.body))
+		if err != nil {
+			t.Errorf("%s %s: %v", test.method, test.path, err)
+			continue
+		}
+		if string(response.Body) != test.want || response.Status != 0 {
+			t.Errorf("%s %s = %d %q, want 0 %q", test.method, test.path, response.Status, response.Body, test.want)
+		}
+	}
+
+	response, err := Request(context.Background(), connection, "GET", "/status/404", nil)
+	if response == nil || response.Status != http.StatusNotFound || !strings.Contains(string(response.Body), "no such index") {
+		t.Errorf("failed request returned response %+v", response)
+	}
+	if KindOf(err) != ErrorIndexNotFound {
+		t.Errorf("failed request returned %v, want index not found error", err)
+	}
+
+	if _, err := Request(context.Background(), connection, "PATCH", "/logs", nil); err == nil {
+		t.Error("PATCH succeeded, want unsupported method error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Request(ctx, connection, "GET", "/", nil); err != context.Canceled {
+		t.Errorf("cancelled request returned %v, want context.Canceled", err)
+	}
+}
+
+func TestIsMethod(t *testing.T) {
+	for _, method := range []string{"GET", "head", "Post", "PUT", "delete"} {
+		if !isMethod(method) {
+			t.Errorf("isMethod(%s) = false, want true", method)
+		}
+	}
+	for _, method := range []string{"PATCH", "OPTIONS", ""} {
+		if isMethod(method) {
+			t.Errorf("isMethod(%s) = true, want false", method)
+		}
+	}
+}
+
+func TestAddParam(t *testing.T) {
+	tests := []struct {
+		path  string
+		name  string
+		value string
+		want  string
+	}{
+		{"/_cluster/health", "filter_path", "status", "/_cluster/health?filter_path=status"},
+		{"/_cat/indices?v", "s", "index", "/_cat/indices?v&s=index"},
+		{"/logs/_search", "filter_path", "hits.total,aggregations", "/logs/_search?filter_path=hits.total%2Caggregations"},
+		{"/logs/_search", "q", "a b&c", "/logs/_search?q=a+b%26c"},
+	}
+	for _, test := range tests {
+		if got := AddParam(test.path, test.name, test.value); got != test.want {
+			t.Errorf("AddParam(%s, %s, %s) = %s, want %s", test.path, test.name, test.value, got, test.want)
+		}
+	}
+}
+
+func TestIsSearchEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     bool
+	}{
+		{"_search", true},
+		{"/logs-*/_search?routing=web1", true},
+		{"logs/_search/", true},
+		{"logs/_search/template", true},
+		{"_search/scroll", true},
+		{"logs/_count", false},
+		{"_cluster/state", false},
+		{"_cat/indices?v", false},
+		{"my_search", false},
+	}
+	for _, test := range tests {
+		if got := IsSearchEndpoint(test.endpoint); got != test.want {
+			t.Errorf("IsSearchEndpoint(%s) = %v, want %v", test.endpoint, got, test.want)
+		}
+	}
+}
+
+func TestSendText(t *testing.T) {
+	g := newTestGobana(t, http.HandlerFunc(echoRequest), "")
+	result, err := g.send(context.Background(), "GET", "status/text", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Body) != "green open logs-1\n" {
+		t.Errorf("Body = %q", result.Body)
+	}
+}
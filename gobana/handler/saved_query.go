@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"go.yaml.in/yaml/v3"
+)
+
+// SavedQueryExtensions are the extensions of saved query files in the
+// query library.
+var SavedQueryExtensions = []string{".yml", ".yaml"}
+
+// SavedQuery is a query saved in the query library as NAME.yml. The query
+// is given by one of Query (rendered as Go template with the params),
+// Queryfile (relative to the library), KQL, Lucene or TemplateId. With
+// Mustache, Query or Queryfile is an inline Mustache search template. The
+// other settings correspond to the command line flags of gobana.
+type SavedQuery struct {
+	Name        string            `yaml:"-"`
+	File        string            `yaml:"-"`
+	Description string            `yaml:"description"`
+	Endpoint    string            `yaml:"endpoint"`
+	Method      string            `yaml:"method"`
+	Query       string            `yaml:"query"`
+	Queryfile   string            `yaml:"queryfile"`
+	KQL         string            `yaml:"kql"`
+	Lucene      string            `yaml:"lucene"`
+	TemplateId  string            `yaml:"template_id"`
+	Mustache    bool              `yaml:"mustache"`
+	Params      []SavedQueryParam `yaml:"params"`
+	Last        string            `yaml:"last"`
+	From        string            `yaml:"from"`
+	To          string            `yaml:"to"`
+	TimeField   string            `yaml:"time_field"`
+	Size        *int              `yaml:"size"`
+	Sort        []string          `yaml:"sort"`
+	Fields      []string          `yaml:"fields"`
+	Output      string            `yaml:"output"`
+	Columns     []string          `yaml:"columns"`
+	SingleValue []string          `yaml:"singlevalue"`
+	Aggregation string            `yaml:"aggregation"`
+	Flatten     bool              `yaml:"flatten"`
+	All         bool              `yaml:"all"`
+}
+
+// SavedQueryParam documents a parameter of a saved query. Default is used
+// if the parameter is not given, a Required parameter must be given.
+type SavedQueryParam struct {
+	Name        string      `yaml:"name"`
+	Description string      `yaml:"description"`
+	Default     interface{} `yaml:"default"`
+	Required    bool        `yaml:"required"`
+}
+
+// UnmarshalYAML decodes the param. Dates and times in Default are kept as
+// they are written instead of being converted to time values.
+func (p *SavedQueryParam) UnmarshalYAML(Node *yaml.Node) error {
+	var raw struct {
+		Name        string    `yaml:"name"`
+		Description string    `yaml:"description"`
+		Default     yaml.Node `yaml:"default"`
+		Required    bool      `yaml:"required"`
+	}
+	if err := Node.Decode(&raw); err != nil {
+		return err
+	}
+	*p = SavedQueryParam{Name: raw.Name, Description: raw.Description, Required: raw.Required}
+	if raw.Default.Kind == yaml.ScalarNode && raw.Default.Tag == "!!timestamp" {
+		p.Default = raw.Default.Value
+		return nil
+	}
+	if raw.Default.Kind == 0 {
+		return nil
+	}
+	return raw.Default.Decode(&p.Default)
+}
+
+// SavedQueries returns all saved queries of the library sorted by name.
+func SavedQueries(Library string) ([]*SavedQuery, error) {
+	logger := log.WithField("func", "SavedQueries")
+
+	entries, err := os.ReadDir(Library)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	var queries []*SavedQuery
+	for _, entry := range entries {
+		name, ok := savedQueryName(entry.Name())
+		if entry.IsDir() || !ok {
+			continue
+		}
+		q, err := readSavedQuery(filepath.Join(Library, entry.Name()), name)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Name < queries[j].Name })
+	return queries, nil
+}
+
+// LoadSavedQuery reads the saved query from the library.
+func LoadSavedQuery(Library string, Name string) (*SavedQuery, error) {
+	logger := log.WithFields(log.Fields{"func": "LoadSavedQuery", "name": Name})
+
+	for _, ext := range SavedQueryExtensions {
+		file := filepath.Join(Library, Name+ext)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		q, err := readSavedQuery(file, Name)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+		return q, nil
+	}
+	err := errors.New("Unknown saved query '" + Name + "' in library '" + Library + "'")
+	logger.Error(err)
+	return nil, err
+}
+
+func savedQueryName(FileName string) (string, bool) {
+	ext := filepath.Ext(FileName)
+	for _, e := range SavedQueryExtensions {
+		if strings.EqualFold(ext, e) {
+			return strings.TrimSuffix(FileName, ext), true
+		}
+	}
+	return "", false
+}
+
+func readSavedQuery(FileName string, Name string) (*SavedQuery, error) {
+	buf, err := os.ReadFile(FileName)
+	if err != nil {
+		return nil, err
+	}
+	q := &SavedQuery{Name: Name, File: FileName}
+	decoder := yaml.NewDecoder(bytes.NewReader(buf))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(q); err != nil {
+		return nil, errors.New("Invalid saved query '" + FileName + "': " + err.Error())
+	}
+	if err := q.validate(); err != nil {
+		return nil, errors.New("Invalid saved query '" + FileName + "': " + err.Error())
+	}
+	if q.Queryfile != "" && !filepath.IsAbs(q.Queryfile) {
+		q.Queryfile = filepath.Join(filepath.Dir(FileName), q.Queryfile)
+	}
+	return q, nil
+}
+
+func (q *SavedQuery) validate() error {
+	var given []string
+	for name, value := range map[string]string{
+		"query":       q.Query,
+		"queryfile":   q.Queryfile,
+		"kql":         q.KQL,
+		"lucene":      q.Lucene,
+		"template_id": q.TemplateId,
+	} {
+		if value != "" {
+			given = append(given, name)
+		}
+	}
+	if len(given) > 1 {
+		sort.Strings(given)
+		return errors.New("exclusive settings " + strings.Join(given, " and ") + " used at the same time")
+	}
+	for i, p := range q.Params {
+		if p.Name == "" {
+			return errors.New("param " + strconv.Itoa(i+1) + " has no name")
+		}
+	}
+	return nil
+}
+
+// IsTemplate returns true if the query is rendered as Go template.
+func (q *SavedQuery) IsTemplate() bool {
+	return (q.Query != "" || q.Queryfile != "") && !q.Mustache
+}
+
+// Data returns the key=value pairs for the params: the defaults followed by
+// Data, so Data overrides the defaults. String defaults are passed as
+// written, other defaults are encoded as json to keep their type. Required
+// params missing in Data and the DataFiles are an error.
+func (q *SavedQuery) Data(Data []string, DataFiles []string) ([]string, error) {
+	given, err := TemplateData(TemplateOptions{Data: Data, DataFiles: DataFiles})
+	if err != nil {
+		return nil, err
+	}
+	var data []string
+	for _, p := range q.Params {
+		if _, ok := given[p.Name]; ok {
+			continue
+		}
+		if p.Required {
+			return nil, errors.New("Missing required param '" + p.Name + "' of saved query '" + q.Name + "', use -d " + p.Name + "=value")
+		}
+		if p.Default == nil {
+			continue
+		}
+		if text, ok := p.Default.(string); ok {
+			data = append(data, p.Name+"="+text)
+			continue
+		}
+		value, err := json.Marshal(p.Default)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, p.Name+"="+string(value))
+	}
+	return append(data, Data...), nil
+}
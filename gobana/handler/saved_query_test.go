@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadSavedQuery(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"errors.yml": `description: Errors
+endpoint: logs-*/_search
+queryfile: errors.json
+params:
+  - name: Hosts
+    default: [web1, web2]
+  - name: Day
+    default: 2024-11-04
+  - name: Index
+    required: true
+size: 5
+`,
+		"both.yml":    "kql: 'a: 1'\nlucene: 'a:1'\n",
+		"unknown.yml": "qurey: '{}'\n",
+		"noname.yml":  "kql: 'a: 1'\nparams:\n  - description: x\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, err := LoadSavedQuery(dir, "errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Queryfile != filepath.Join(dir, "errors.json") {
+		t.Errorf("Queryfile = %q, want it relative to the library", q.Queryfile)
+	}
+	if q.Size == nil || *q.Size != 5 {
+		t.Errorf("Size = %v, want 5", q.Size)
+	}
+	if !q.IsTemplate() {
+		t.Error("IsTemplate = false, want true")
+	}
+	if q.Params[1].Default != "2024-11-04" {
+		t.Errorf("Default of Day = %#v, want the date as written", q.Params[1].Default)
+	}
+
+	for _, name := range []string{"both", "unknown", "noname", "missing"} {
+		if _, err := LoadSavedQuery(dir, name); err == nil {
+			t.Errorf("LoadSavedQuery(%q) succeeded, want error", name)
+		}
+	}
+}
+
+func TestSavedQueryData(t *testing.T) {
+	q := &SavedQuery{Name: "errors", Params: []SavedQueryParam{
+		{Name: "Hosts", Default: []interface{}{"web1", "web2"}},
+		{Name: "MinStatus", Default: 500},
+		{Name: "Level", Default: "error"},
+		{Name: "Message"},
+		{Name: "Index", Required: true},
+	}}
+	tests := []struct {
+		name string
+		data []string
+		want []string
+	}{
+		{"defaults", []string{"Index=logs"},
+			[]string{`Hosts=["web1","web2"]`, "MinStatus=500", "Level=error", "Index=logs"}},
+		{"override", []string{"Index=logs", "MinStatus=400"},
+			[]string{`Hosts=["web1","web2"]`, "Level=error", "Index=logs", "MinStatus=400"}},
+		{"missing required", []string{"MinStatus=400"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := q.Data(test.data, nil)
+			if test.want == nil {
+				if err == nil {
+					t.Errorf("Data = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Data = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/joernott/lra"
+	log "github.com/sirupsen/logrus"
+)
+
+// SearchTemplate is the body of a _search/template or _render/template
+// request, either with the Id of a stored template or with an inline
+// Mustache Source.
+type SearchTemplate struct {
+	Id     string                 `json:"id,omitempty"`
+	Source string                 `json:"source,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// searchTemplateQuery returns the body of the search template request for
+// the options. Source is the Mustache source for inline templates.
+func searchTemplateQuery(Source string, Options GobanaOptions) (string, error) {
+	if Options.TemplateId != "" && Options.Mustache {
+		return "", errors.New("Can't use a stored template and an inline Mustache template at the same time")
+	}
+	if Options.Mustache && strings.TrimSpace(Source) == "" {
+		return "", errors.New("An inline Mustache template needs a query or queryfile")
+	}
+	if !Options.TimeRange.IsZero() || Options.Size != nil || len(Options.Sort) > 0 || len(Options.Fields) > 0 {
+		return "", errors.New("Can't use time range, size, sort or fields with a search template, pass them as params")
+	}
+	params, err := TemplateData(TemplateOptions{Data: Options.Data, DataFiles: Options.DataFiles})
+	if err != nil {
+		return "", err
+	}
+	delete(params, "Env")
+	body := SearchTemplate{Id: Options.TemplateId, Params: params}
+	if Options.Mustache {
+		body.Source = Source
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// searchTemplateEndpoint returns the search template endpoint for a search
+// endpoint like logs-*/_search?routing=web1.
+func searchTemplateEndpoint(Endpoint string) string {
+	path, params := Endpoint, ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, params = path[:i], path[i:]
+	}
+	path = strings.TrimSuffix(path, "/")
+	if strings.HasSuffix(path, "_search") {
+		return path + "/template" + params
+	}
+	return Endpoint
+}
+
+// RenderSearchTemplate renders the search template of the Gobana with
+// _render/template and returns the resulting query.
+func (gobana *Gobana) RenderSearchTemplate(ctx context.Context) (json.RawMessage, error) {
+	logger := log.WithField("func", "Gobana.RenderSearchTemplate")
+
+	if !gobana.searchTemplate {
+		err := errors.New("Rendering needs a stored or inline search template")
+		logger.Error(err)
+		return nil, err
+	}
+	response, err := Request(ctx, gobana.Connection, http.MethodPost, "/_render/template", []byte(gobana.Query))
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	var result struct {
+		TemplateOutput json.RawMessage `json:"template_output"`
+	}
+	err = json.Unmarshal(response.Body, &result)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return result.TemplateOutput, nil
+}
+
+// StoredTemplates returns the Mustache templates stored in the cluster as
+// records with the columns id and source.
+func StoredTemplates(ctx context.Context, Connection *lra.Connection) ([]Record, error) {
+	var result struct {
+		Metadata struct {
+			StoredScripts map[string]struct {
+				Lang   string `json:"lang"`
+				Source string `json:"source"`
+			} `json:"stored_scripts"`
+		} `json:"metadata"`
+	}
+	err := getJson(ctx, Connection, "/_cluster/state/metadata?filter_path=metadata.stored_scripts", &result)
+	if err != nil {
+		return nil, err
+	}
+	scripts := result.Metadata.StoredScripts
+	var records []Record
+	for _, id := range sortedKeys(scripts) {
+		if scripts[id].Lang != "mustache" {
+			continue
+		}
+		records = append(records, Record{
+			Fields: []string{"id", "source"},
+			Values: map[string]interface{}{"id": id, "source": scripts[id].Source},
+		})
+	}
+	return records, nil
+}
+
+// GetTemplate returns the source of the stored Mustache template.
+func GetTemplate(ctx context.Context, Connection *lra.Connection, Id string) (string, error) {
+	var result struct {
+		Found  bool `json:"found"`
+		Script struct {
+			Source string `json:"source"`
+		} `json:"script"`
+	}
+	err := getJson(ctx, Connection, scriptPath(Id), &result)
+	var requestError *RequestError
+	if errors.As(err, &requestError) && requestError.Status == http.StatusNotFound && requestError.Err == nil {
+		err = errors.New("Template '" + Id + "' not found")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"func": "GetTemplate", "id": Id}).Error(err)
+		return "", err
+	}
+	return result.Script.Source, nil
+}
+
+// PutTemplate stores the Mustache template in the cluster, replacing a
+// template with the same id.
+func PutTemplate(ctx context.Context, Connection *lra.Connection, Id string, Source string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]string{"lang": "mustache", "source": Source},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = Request(ctx, Connection, http.MethodPut, scriptPath(Id), body)
+	return err
+}
+
+// DeleteTemplate deletes the stored template.
+func DeleteTemplate(ctx context.Context, Connection *lra.Connection, Id string) error {
+	_, err := Request(ctx, Connection, http.MethodDelete, scriptPath(Id), nil)
+	return err
+}
+
+// scriptPath returns the path of the stored script, with the Id escaped so
+// a /, ? or # in it can't reach another endpoint.
+func scriptPath(Id string) string {
+	return "/_scripts/" + url.PathEscape(Id)
+}
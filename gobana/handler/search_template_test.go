@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestSearchTemplateQuery(t *testing.T) {
+	size := 10
+	tests := []struct {
+		name    string
+		source  string
+		options GobanaOptions
+		want    string
+	}{
+		{"stored", "", GobanaOptions{TemplateId: "errors", Data: []string{"Size=5", "Hosts=[a, b]"}},
+			`{"id":"errors","params":{"Hosts":["a","b"],"Size":5}}`},
+		{"inline", `{"size": {{Size}}}`, GobanaOptions{Mustache: true},
+			`{"source":"{\"size\": {{Size}}}"}`},
+		{"both", "", GobanaOptions{TemplateId: "errors", Mustache: true}, ""},
+		{"inline without source", " ", GobanaOptions{Mustache: true}, ""},
+		{"size", "", GobanaOptions{TemplateId: "errors", Size: &size}, ""},
+		{"invalid data", "", GobanaOptions{TemplateId: "errors", Data: []string{"Size"}}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := searchTemplateQuery(test.source, test.options)
+			if test.want == "" {
+				if err == nil {
+					t.Errorf("searchTemplateQuery = %s, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("searchTemplateQuery = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSearchTemplateEndpoint(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"_search":                 "_search/template",
+		"logs-*/_search":          "logs-*/_search/template",
+		"logs-*/_search/template": "logs-*/_search/template",
+		"logs/_search?routing=x":  "logs/_search/template?routing=x",
+		"logs/_search/?routing=x": "logs/_search/template?routing=x",
+		"logs/_count":             "logs/_count",
+	} {
+		if got := searchTemplateEndpoint(endpoint); got != want {
+			t.Errorf("searchTemplateEndpoint(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
+
+func TestTemplatePaths(t *testing.T) {
+	var paths []string
+	connection := newTestGobana(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.EscapedPath())
+		w.Write([]byte(`{"acknowledged":true,"found":true,"script":{"source":"{}"}}`))
+	}), "").Connection
+	ctx := context.Background()
+	if _, err := GetTemplate(ctx, connection, "a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := PutTemplate(ctx, connection, "errors?x=1", "{}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteTemplate(ctx, connection, "../_all#x"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"GET /_scripts/a%2Fb", "PUT /_scripts/errors%3Fx=1", "DELETE /_scripts/..%2F_all%23x"}
+	for i := range want {
+		if i >= len(paths) || paths[i] != want[i] {
+			t.Errorf("requests = %v, want %v", paths, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/joernott/elasticsearch-tools/gobana/query"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTailLines is the number of documents Tail shows first.
+const DefaultTailLines = 10
+
+// DefaultTailInterval is the time between the polls of Tail.
+const DefaultTailInterval = 2 * time.Second
+
+// tailTimeFormat is the format of the timestamps in the sort values and
+// range queries of Tail. Unlike epoch_millis it works for date and
+// date_nanos fields and keeps the nanoseconds of the latter.
+const tailTimeFormat = "strict_date_optional_time_nanos"
+
+// TailOptions configures Tail. The documents are sorted by TimeField
+// (default @timestamp) and the Tiebreaker, a field with a unique value per
+// document like a sequence number. Without a Tiebreaker, documents with
+// the timestamp of the newest document are fetched again and skipped by
+// _index and _id. Lines is the number of documents shown first, Interval
+// the time between polls (DefaultTailInterval if zero) and OnError is
+// called for failed polls like in WatchOptions.
+type TailOptions struct {
+	TimeField  string
+	Tiebreaker string
+	Lines      int
+	Interval   time.Duration
+	PageSize   int
+	OnError    func(Err error, Delay time.Duration) bool
+}
+
+// tail keeps the position of Tail in the sorted documents.
+type tail struct {
+	gobana   *Gobana
+	options  TailOptions
+	endpoint string
+	query    interface{}
+	start    time.Time
+	started  bool
+	// after holds the sort values of the newest document seen.
+	after []interface{}
+	// seen holds the keys of the documents with the timestamp in after,
+	// used without a tiebreaker.
+	seen map[string]bool
+}
+
+// Tail follows the documents matching the query of the Gobana like tail
+// -f. It passes the last Lines documents to handle, then polls for newer
+// ones every Interval using search_after and passes them in ascending
+// order, until ctx is cancelled. Failed polls are retried like in Watch.
+// Only the "query" of the query is used, size, sort and aggregations are
+// replaced. Documents indexed with a timestamp older than the newest
+// document already shown are not found.
+func (gobana *Gobana) Tail(ctx context.Context, Options TailOptions, handle PageHandler) error {
+	logger := log.WithField("func", "Gobana.Tail")
+
+	if gobana.searchTemplate {
+		err := errors.New("Can't tail a search template, use a query instead")
+		logger.Error(err)
+		return err
+	}
+	index, params, err := splitSearchEndpoint(gobana.Endpoint)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	body, err := queryBody(gobana.Query)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if Options.TimeField == "" {
+		Options.TimeField = query.DefaultTimeField
+	}
+	if Options.Interval <= 0 {
+		Options.Interval = DefaultTailInterval
+	}
+	if Options.PageSize <= 0 {
+		Options.PageSize = DefaultPageSize
+	}
+	t := &tail{
+		gobana:   gobana,
+		options:  Options,
+		endpoint: strings.TrimPrefix(index+"/_search", "/") + params,
+		query:    body["query"],
+		start:    time.Now(),
+	}
+	return Watch(ctx, WatchOptions{Interval: Options.Interval, OnError: Options.OnError}, func(ctx context.Context) error {
+		if !t.started {
+			return t.last(ctx, handle)
+		}
+		return t.poll(ctx, handle)
+	})
+}
+
+// last fetches the newest documents and passes the last Lines of them to
+// handle in ascending order.
+func (t *tail) last(ctx context.Context, handle PageHandler) error {
+	size := t.options.Lines
+	if size < 1 {
+		size = 1
+	}
+	body := t.body("desc", size)
+	if t.query != nil {
+		body["query"] = t.query
+	}
+	page, err := t.gobana.search(ctx, t.endpoint, body)
+	if err != nil {
+		return err
+	}
+	t.started = true
+	hits := page.Hits.Hits
+	if len(hits) == 0 {
+		return nil
+	}
+	if err := t.advance(hits[:1]); err != nil {
+		return err
+	}
+	t.markSeen(hits)
+	if t.options.Lines < 1 {
+		return nil
+	}
+	reversed := make([]ElasticsearchHitList, len(hits))
+	for i, hit := range hits {
+		reversed[len(hits)-1-i] = hit
+	}
+	page.Hits.Hits = reversed
+	return handle(page)
+}
+
+// poll passes the documents newer than the last one seen to handle, page
+// by page until all are fetched.
+func (t *tail) poll(ctx context.Context, handle PageHandler) error {
+	for {
+		size := t.options.PageSize + len(t.seen)
+		body := t.body("asc", size)
+		switch {
+		case t.after == nil:
+			body["query"] = t.rangeQuery(t.start.UTC().Format(time.RFC3339Nano))
+		case t.options.Tiebreaker != "":
+			body["search_after"] = t.after
+			if t.query != nil {
+				body["query"] = t.query
+			}
+		default:
+			body["query"] = t.rangeQuery(t.after[0])
+		}
+		page, err := t.gobana.search(ctx, t.endpoint, body)
+		if err != nil {
+			return err
+		}
+		hits := page.Hits.Hits
+		if len(hits) == 0 {
+			return nil
+		}
+		page.Hits.Hits = nil
+		for _, hit := range hits {
+			if !t.seen[hit.Key()] {
+				page.Hits.Hits = append(page.Hits.Hits, hit)
+			}
+		}
+		if err := t.advance(hits); err != nil {
+			return err
+		}
+		t.markSeen(hits)
+		if len(page.Hits.Hits) > 0 {
+			if err := handle(page); err != nil {
+				return err
+			}
+		}
+		if len(hits) < size {
+			return nil
+		}
+	}
+}
+
+// advance moves the position to the newest of the hits, which are sorted
+// ascending with the newest at the end or descending with only the newest.
+// Without tiebreaker, the documents seen are forgotten when the timestamp
+// changes.
+func (t *tail) advance(Hits []ElasticsearchHitList) error {
+	newest := Hits[len(Hits)-1]
+	if len(newest.Sort) == 0 {
+		err := errors.New("Hit without sort values, can't continue with search_after")
+		log.WithField("func", "tail.advance").Error(err)
+		return err
+	}
+	if t.options.Tiebreaker == "" && (t.after == nil || newest.sortValue() != t.after[0]) {
+		t.seen = make(map[string]bool)
+	}
+	t.after = newest.Sort
+	return nil
+}
+
+// markSeen remembers the hits with the timestamp of the newest document,
+// if there is no tiebreaker.
+func (t *tail) markSeen(Hits []ElasticsearchHitList) {
+	if t.seen == nil {
+		return
+	}
+	for _, hit := range Hits {
+		if hit.sortValue() == t.after[0] {
+			t.seen[hit.Key()] = true
+		}
+	}
+}
+
+// body returns the search body sorted by the time field and the
+// tiebreaker in the Order.
+func (t *tail) body(Order string, Size int) map[string]interface{} {
+	sort := []interface{}{map[string]interface{}{
+		t.options.TimeField: map[string]interface{}{"order": Order, "unmapped_type": "date", "format": tailTimeFormat},
+	}}
+	if t.options.Tiebreaker != "" {
+		sort = append(sort, map[string]interface{}{
+			t.options.Tiebreaker: map[string]interface{}{"order": Order},
+		})
+	}
+	return map[string]interface{}{"size": Size, "sort": sort}
+}
+
+// rangeQuery restricts the query to documents with a timestamp at or
+// after From, given in the tailTimeFormat like the sort values.
+func (t *tail) rangeQuery(From interface{}) map[string]interface{} {
+	filter := map[string]interface{}{"range": map[string]interface{}{
+		t.options.TimeField: map[string]interface{}{"gte": From, "format": tailTimeFormat},
+	}}
+	boolQuery := map[string]interface{}{"filter": []interface{}{filter}}
+	if t.query != nil {
+		boolQuery["must"] = []interface{}{t.query}
+	}
+	return map[string]interface{}{"bool": boolQuery}
+}
+
+// sortValue returns the first sort value of the hit, the timestamp when
+// tailing.
+func (hit *ElasticsearchHitList) sortValue() interface{} {
+	if len(hit.Sort) == 0 {
+		return nil
+	}
+	return hit.Sort[0]
+}
+
+// NewLineTemplate parses a Go template for WriteLines. In addition to
+// the TemplateFuncs, field PATH DOCUMENT returns the formatted value of a
+// path like host.name, an empty string if it is missing, e.g.
+// {{ field "@timestamp" . }} {{ field "log.level" . }} {{ field "message" . }}.
+func NewLineTemplate(Text string) (*template.Template, error) {
+	if !strings.HasSuffix(Text, "\n") {
+		Text += "\n"
+	}
+	tmpl, err := template.New("line").Funcs(TemplateFuncs()).Funcs(template.FuncMap{
+		"field": field,
+	}).Parse(Text)
+	if err != nil {
+		log.WithField("func", "NewLineTemplate").Error(err)
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func field(Path string, Document interface{}) string {
+	value, ok := LookupPath(Document, Path)
+	if !ok {
+		return ""
+	}
+	return FormatValue(value)
+}
+
+// WriteLines writes every hit as one line rendered with the Template. The
+// template data is the _source with the additional keys _index and _id.
+// Without Template, the _source is written as one line of json.
+func (result *ElasticsearchResult) WriteLines(Writer io.Writer, Template *template.Template) error {
+	logger := log.WithField("func", "ElasticsearchResult.WriteLines")
+
+	for _, hit := range result.Hits.Hits {
+		if Template == nil {
+			line, err := json.Marshal(hit.Source)
+			if err != nil {
+				logger.Error(err)
+				return err
+			}
+			if _, err := Writer.Write(append(line, '\n')); err != nil {
+				logger.Error(err)
+				return err
+			}
+			continue
+		}
+		data := make(map[string]interface{}, len(hit.Source)+2)
+		for key, value := range hit.Source {
+			data[key] = value
+		}
+		data["_index"] = hit.Index
+		data["_id"] = hit.Id
+		if err := Template.Execute(Writer, data); err != nil {
+			logger.Error(err)
+			return err
+		}
+	}
+	return nil
+}
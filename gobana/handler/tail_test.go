@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestTailAdvance(t *testing.T) {
+	hit := func(id string, ts float64) ElasticsearchHitList {
+		return ElasticsearchHitList{Index: "logs", Id: id, Sort: []interface{}{ts}}
+	}
+	tl := &tail{}
+	first := []ElasticsearchHitList{hit("3", 20), hit("2", 20), hit("1", 10)}
+	if err := tl.advance(first[:1]); err != nil {
+		t.Fatal(err)
+	}
+	tl.markSeen(first)
+	if len(tl.seen) != 2 || !tl.seen["logs/2"] || !tl.seen["logs/3"] {
+		t.Errorf("seen = %v, want logs/2 and logs/3", tl.seen)
+	}
+
+	tl.markSeen([]ElasticsearchHitList{hit("4", 20)})
+	if len(tl.seen) != 3 {
+		t.Errorf("seen = %v, want 3 documents with the same timestamp", tl.seen)
+	}
+	if err := tl.advance([]ElasticsearchHitList{hit("4", 20), hit("5", 30)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(tl.seen) != 0 || tl.after[0] != 30.0 {
+		t.Errorf("after = %v, seen = %v, want 30 and no documents", tl.after, tl.seen)
+	}
+	if err := tl.advance([]ElasticsearchHitList{{Id: "6"}}); err == nil {
+		t.Error("advance without sort values succeeded, want error")
+	}
+
+	tl = &tail{options: TailOptions{Tiebreaker: "seq"}}
+	tl.advance([]ElasticsearchHitList{hit("1", 10)})
+	tl.markSeen([]ElasticsearchHitList{hit("1", 10)})
+	if tl.seen != nil {
+		t.Errorf("seen = %v with tiebreaker, want nil", tl.seen)
+	}
+}
+
+func TestTailBody(t *testing.T) {
+	tl := &tail{options: TailOptions{TimeField: "@timestamp", Tiebreaker: "seq"}, query: map[string]interface{}{"match_all": map[string]interface{}{}}}
+	tests := []struct {
+		name string
+		body interface{}
+		want string
+	}{
+		{"sort", tl.body("desc", 10),
+			`{"size":10,"sort":[{"@timestamp":{"format":"strict_date_optional_time_nanos","order":"desc","unmapped_type":"date"}},{"seq":{"order":"desc"}}]}`},
+		{"range", tl.rangeQuery("2024-11-04T10:00:00.123456789Z"),
+			`{"bool":{"filter":[{"range":{"@timestamp":{"format":"strict_date_optional_time_nanos","gte":"2024-11-04T10:00:00.123456789Z"}}}],"must":[{"match_all":{}}]}}`},
+	}
+	for _, test := range tests {
+		got, err := json.Marshal(test.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%s = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWriteLines(t *testing.T) {
+	result := &ElasticsearchResult{Hits: ElasticsearchHitResult{Hits: []ElasticsearchHitList{
+		{Index: "logs", Id: "1", Source: map[string]interface{}{"message": "started", "host": map[string]interface{}{"name": "web1"}}},
+		{Index: "logs", Id: "2", Source: map[string]interface{}{"message": "stopped"}},
+	}}}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"json", "", "{\"host\":{\"name\":\"web1\"},\"message\":\"started\"}\n{\"message\":\"stopped\"}\n"},
+		{"template", `{{ ._id }} {{ field "host.name" . | default "-" }} {{ field "message" . }}`, "1 web1 started\n2 - stopped\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var err error
+			if test.template == "" {
+				err = result.WriteLines(&buf, nil)
+			} else {
+				tmpl, perr := NewLineTemplate(test.template)
+				if perr != nil {
+					t.Fatal(perr)
+				}
+				err = result.WriteLines(&buf, tmpl)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.want {
+				t.Errorf("WriteLines = %q, want %q", buf.String(), test.want)
+			}
+		})
+	}
+}
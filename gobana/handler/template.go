@@ -0,0 +1,354 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	log "github.com/sirupsen/logrus"
+	"go.yaml.in/yaml/v3"
+)
+
+// TemplateOptions configures the rendering of a query template. Data are
+// key=value pairs, 10 is a number, true a boolean, [a, b] a list and
+// {"a": 1} a map. Lists and maps print as json with {{ .Key }}. All other
+// values stay strings unchanged, including 1.10, 0123, quotes, "a: b" and
+// text after a #.
+// DataFiles are yaml, json or toml files read in order, later files and
+// Data override their keys. Library is a directory with templates which
+// can be used as partials with {{ template "name.json" . }}.
+type TemplateOptions struct {
+	Data      []string
+	DataFiles []string
+	Library   string
+}
+
+// TemplateExtensions are the extensions of the files in the library
+// directory which are parsed as partials.
+var TemplateExtensions = []string{".json", ".tmpl", ".tpl"}
+
+// RenderTemplate renders the query as Go text/template with the data of
+// the options. The environment is available as .Env and with the env
+// function, see TemplateFuncs for the other functions. Using a key which
+// is not set is an error.
+func RenderTemplate(Query string, Options TemplateOptions) (string, error) {
+	logger := log.WithField("func", "RenderTemplate")
+
+	data, err := TemplateData(Options)
+	if err != nil {
+		logger.Error(err)
+		return "", err
+	}
+	t := template.New("query").Funcs(TemplateFuncs()).Option("missingkey=error")
+	if Options.Library != "" {
+		t, err = parseLibrary(t, Options.Library)
+		if err != nil {
+			logger.Error(err)
+			return "", err
+		}
+	}
+	t, err = t.Parse(Query)
+	if err != nil {
+		logger.Error(err)
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		logger.Error(err)
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// TemplateData returns the data for rendering a template: the environment
+// as Env, the contents of the data files and the key=value pairs.
+func TemplateData(Options TemplateOptions) (map[string]interface{}, error) {
+	env := make(map[string]interface{})
+	for _, e := range os.Environ() {
+		k, v, _ := strings.Cut(e, "=")
+		env[k] = v
+	}
+	data := map[string]interface{}{"Env": env}
+	for _, file := range Options.DataFiles {
+		fileData, err := readDataFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileData {
+			data[k] = v
+		}
+	}
+	for _, d := range Options.Data {
+		key, value, found := strings.Cut(d, "=")
+		if !found || key == "" {
+			return nil, errors.New("Invalid template data '" + d + "', use key=value")
+		}
+		data[key] = dataValue(value)
+	}
+	return data, nil
+}
+
+// dataValue converts a value given on the command line into a number or
+// boolean if it keeps its text, and a value starting with [ or { into a
+// dataList or dataMap. Everything else is returned unchanged as string.
+func dataValue(Value string) interface{} {
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(Value), &value); err != nil {
+		return Value
+	}
+	switch v := value.(type) {
+	case []interface{}:
+		if strings.HasPrefix(strings.TrimSpace(Value), "[") {
+			return dataList(v)
+		}
+	case map[string]interface{}:
+		if strings.HasPrefix(strings.TrimSpace(Value), "{") {
+			return dataMap(v)
+		}
+	case bool, int, float64:
+		if fmt.Sprint(v) == Value {
+			return v
+		}
+	}
+	return Value
+}
+
+// dataList is a list given with --data. It prints as json, so it can be
+// used in a query with {{ .Key }} like a list from a data file with
+// {{ toJSON .Key }}.
+type dataList []interface{}
+
+func (list dataList) String() string {
+	text, _ := toJSON([]interface{}(list))
+	return text
+}
+
+// dataMap is a map given with --data, which prints as json like dataList.
+type dataMap map[string]interface{}
+
+func (m dataMap) String() string {
+	text, _ := toJSON(map[string]interface{}(m))
+	return text
+}
+
+// readDataFile reads template data from a yaml, json or toml file.
+func readDataFile(FileName string) (map[string]interface{}, error) {
+	buf, err := os.ReadFile(FileName)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(FileName)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(buf, &data)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(buf))
+		decoder.UseNumber()
+		err = decoder.Decode(&data)
+	case ".toml":
+		err = toml.Unmarshal(buf, &data)
+	default:
+		return nil, errors.New("Unknown data file type '" + FileName + "', use .yml, .yaml, .json or .toml")
+	}
+	if err != nil {
+		return nil, errors.New("Invalid data file '" + FileName + "': " + err.Error())
+	}
+	return data, nil
+}
+
+// parseLibrary adds the templates in the directory as partials named by
+// their file name.
+func parseLibrary(Template *template.Template, Library string) (*template.Template, error) {
+	entries, err := os.ReadDir(Library)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isTemplateFile(entry.Name()) {
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join(Library, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		_, err = Template.New(entry.Name()).Parse(string(buf))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return Template, nil
+}
+
+func isTemplateFile(Name string) bool {
+	ext := strings.ToLower(filepath.Ext(Name))
+	for _, e := range TemplateExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// TemplateFuncs returns the functions available in query templates:
+//
+//	toJSON VALUE               json encoding, strings are quoted
+//	jsonEscape STRING          json escaped string without quotes
+//	default DEFAULT VALUE      DEFAULT if VALUE is nil, "" or an empty list
+//	join SEP LIST              the elements of LIST separated by SEP
+//	env NAME                   environment variable
+//	now                        the current time
+//	parseTime VALUE            time from RFC3339, date or epoch milliseconds
+//	addTime DURATION TIME      TIME plus DURATION like -7d, 2w or 1h30m
+//	formatTime LAYOUT TIME     Go layout, rfc3339, epoch_millis or epoch_second
+//
+// A missing key can be given a default with {{ index . "Key" | default 10 }}.
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"toJSON":     toJSON,
+		"jsonEscape": jsonEscape,
+		"default":    defaultValue,
+		"join":       join,
+		"env":        os.Getenv,
+		"now":        time.Now,
+		"parseTime":  parseTime,
+		"addTime":    addTime,
+		"formatTime": formatTime,
+	}
+}
+
+func toJSON(Value interface{}) (string, error) {
+	buf, err := json.Marshal(Value)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func jsonEscape(Value string) string {
+	buf, _ := json.Marshal(Value)
+	return string(buf[1 : len(buf)-1])
+}
+
+func defaultValue(Default interface{}, Value interface{}) interface{} {
+	if Value == nil {
+		return Default
+	}
+	v := reflect.ValueOf(Value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		if v.Len() == 0 {
+			return Default
+		}
+	}
+	return Value
+}
+
+func join(Separator string, List interface{}) string {
+	v := reflect.ValueOf(List)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Sprint(List)
+	}
+	elements := make([]string, v.Len())
+	for i := range elements {
+		elements[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(elements, Separator)
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseTime(Value interface{}) (time.Time, error) {
+	switch v := Value.(type) {
+	case time.Time:
+		return v, nil
+	case int, int64, float64, json.Number:
+		ms, err := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.UnixMilli(ms), nil
+	}
+	s := fmt.Sprint(Value)
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Invalid time '" + s + "', use RFC3339, a date like 2024-11-04 or epoch milliseconds")
+}
+
+var dayUnitPattern = regexp.MustCompile(`^(\d+)([dw])`)
+
+// addTime adds the duration to the time. Besides the units of Go
+// durations, d (24 hours) and w (7 days) are supported.
+func addTime(Duration string, Time interface{}) (time.Time, error) {
+	t, err := parseTime(Time)
+	if err != nil {
+		return t, err
+	}
+	value := strings.TrimPrefix(Duration, "+")
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+		value = value[1:]
+	}
+	var d time.Duration
+	for {
+		m := dayUnitPattern.FindStringSubmatch(value)
+		if m == nil {
+			break
+		}
+		n, _ := strconv.Atoi(m[1])
+		unit := 24 * time.Hour
+		if m[2] == "w" {
+			unit *= 7
+		}
+		d += time.Duration(n) * unit
+		value = value[len(m[0]):]
+	}
+	if value != "" || d == 0 {
+		rest, err := time.ParseDuration(value)
+		if err != nil || strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+			return t, errors.New("Invalid duration '" + Duration + "', use e.g. -7d, 2w or 1h30m")
+		}
+		d += rest
+	}
+	return t.Add(sign * d), nil
+}
+
+func formatTime(Layout string, Time interface{}) (string, error) {
+	t, err := parseTime(Time)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(Layout) {
+	case "rfc3339":
+		return t.Format(time.RFC3339), nil
+	case "epoch_millis":
+		return strconv.FormatInt(t.UnixMilli(), 10), nil
+	case "epoch_second":
+		return strconv.FormatInt(t.Unix(), 10), nil
+	}
+	return t.Format(Layout), nil
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDataValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  interface{}
+	}{
+		{"10", 10},
+		{"-1.5", -1.5},
+		{"true", true},
+		{"[a, 2]", dataList{"a", 2}},
+		{`{"term": {"a": 1}}`, dataMap{"term": map[string]interface{}{"a": 1}}},
+		{`"10"`, `"10"`},
+		{`"quoted"`, `"quoted"`},
+		{"'single'", "'single'"},
+		{"foo # bar", "foo # bar"},
+		{"10 # count", "10 # count"},
+		{"a: b", "a: b"},
+		{"- a", "- a"},
+		{"now-30d/d", "now-30d/d"},
+		{"2024-11-04", "2024-11-04"},
+		{"1.10", "1.10"},
+		{"0123", "0123"},
+		{"null", "null"},
+		{"", ""},
+		{"a: b: c", "a: b: c"},
+	}
+	for _, test := range tests {
+		if got := dataValue(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("dataValue(%q) = %#v, want %#v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestDataValuePrint(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`Filter={"term":{"a":1}}`, `{"term":{"a":1}}`},
+		{"Hosts=[web1, web2]", `["web1","web2"]`},
+		{"Msg=foo # bar", "foo # bar"},
+		{`Name="quoted"`, `"quoted"`},
+		{"Q=a: b", "a: b"},
+	}
+	for _, test := range tests {
+		got, err := RenderTemplate(`{{ .`+strings.SplitN(test.data, "=", 2)[0]+` }}`, TemplateOptions{Data: []string{test.data}})
+		if err != nil {
+			t.Errorf("-d %s: %v", test.data, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("-d %s renders %s, want %s", test.data, got, test.want)
+		}
+	}
+	got, err := RenderTemplate(`{{ range .Hosts }}{{ . }} {{ end }}{{ index .Filter "term" }} {{ toJSON .Hosts }}`, TemplateOptions{Data: []string{"Hosts=[web1, web2]", `Filter={"term":{"a":1}}`}})
+	if err != nil || got != `web1 web2 map[a:1] ["web1","web2"]` {
+		t.Errorf("range, index and toJSON = %q, %v", got, err)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	yamlFile := write("data.yml", "Hosts: [web1, web2]\nSize: 5\n")
+	jsonFile := write("data.json", `{"Size": 20, "Message": "a \"quoted\" text"}`)
+	tomlFile := write("data.toml", "Field = \"host.name\"\n")
+	library := filepath.Join(dir, "library")
+	if err := os.Mkdir(library, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(library, "hosts.json"), []byte(`{"terms": {"{{ .Field }}": {{ toJSON .Hosts }}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOBANA_TEST_INDEX", "logs")
+	options := TemplateOptions{
+		Data:      []string{"Size=30", "Day=2024-11-04"},
+		DataFiles: []string{yamlFile, jsonFile, tomlFile},
+		Library:   library,
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{`{{ template "hosts.json" . }}`, `{"terms": {"host.name": ["web1","web2"]}}`},
+		{`{{ .Size }} {{ add1 }}`, ""},
+		{`{{ .Size }}`, "30"},
+		{`"{{ jsonEscape .Message }}"`, `"a \"quoted\" text"`},
+		{`{{ join "," .Hosts }}`, "web1,web2"},
+		{`{{ .Env.GOBANA_TEST_INDEX }}-{{ env "GOBANA_TEST_INDEX" }}`, "logs-logs"},
+		{`{{ index . "Last" | default "15m" }}`, "15m"},
+		{`{{ addTime "-1d" .Day | formatTime "2006-01-02" }}`, "2024-11-03"},
+		{`{{ addTime "1w12h" .Day | formatTime "2006-01-02 15:04" }}`, "2024-11-11 12:00"},
+	}
+	for _, test := range tests {
+		got, err := RenderTemplate(test.template, options)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("RenderTemplate(%q) returned no error", test.template)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("RenderTemplate(%q) failed: %v", test.template, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("RenderTemplate(%q) = %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestRenderTemplateErrors(t *testing.T) {
+	tests := []struct {
+		template string
+		data     []string
+		contains string
+	}{
+		{`{"gte": "{{ .Von }}"}`, nil, `map has no entry for key "Von"`},
+		{`{{ .Von }}`, []string{"Von"}, "Invalid template data 'Von'"},
+		{`{{ addTime "7x" now }}`, nil, "Invalid duration '7x'"},
+	}
+	for _, test := range tests {
+		_, err := RenderTemplate(test.template, TemplateOptions{Data: test.data})
+		if err == nil || !strings.Contains(err.Error(), test.contains) {
+			t.Errorf("RenderTemplate(%q) = %v, want error containing %q", test.template, err, test.contains)
+		}
+	}
+}
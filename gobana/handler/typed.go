@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Hit is a hit with its _source decoded into T.
+type Hit[T any] struct {
+	Index     string
+	Id        string
+	Score     float64
+	Sort      []interface{}
+	Highlight map[string][]string
+	InnerHits map[string]ElasticsearchInnerHits
+	Source    T
+}
+
+// errStopIteration ends ExecuteAll when the loop over Pages is left early.
+var errStopIteration = errors.New("Iteration stopped")
+
+// DecodeHit decodes the _source of the hit into T. Hits without _source
+// leave Source at its zero value.
+func DecodeHit[T any](Raw *ElasticsearchHitList) (Hit[T], error) {
+	hit := Hit[T]{
+		Index:     Raw.Index,
+		Id:        Raw.Id,
+		Score:     Raw.Score,
+		Sort:      Raw.Sort,
+		Highlight: Raw.Highlight,
+		InnerHits: Raw.InnerHits,
+	}
+	source := Raw.RawSource
+	if len(source) == 0 && Raw.Source != nil {
+		var err error
+		source, err = json.Marshal(Raw.Source)
+		if err != nil {
+			return hit, err
+		}
+	}
+	if len(source) == 0 {
+		return hit, nil
+	}
+	err := json.Unmarshal(source, &hit.Source)
+	return hit, err
+}
+
+// DecodeHits decodes the _source of all hits of the result into T.
+func DecodeHits[T any](Result *ElasticsearchResult) ([]Hit[T], error) {
+	return decodeHitList[T](Result.Hits.Hits)
+}
+
+// DecodeInnerHits decodes the _source of the inner hits Name of the hit
+// into T. Unknown names return no hits.
+func DecodeInnerHits[T any](Raw *ElasticsearchHitList, Name string) ([]Hit[T], error) {
+	inner, ok := Raw.InnerHits[Name]
+	if !ok {
+		return nil, nil
+	}
+	return decodeHitList[T](inner.Hits.Hits)
+}
+
+func decodeHitList[T any](List []ElasticsearchHitList) ([]Hit[T], error) {
+	logger := log.WithField("func", "decodeHitList")
+
+	hits := make([]Hit[T], 0, len(List))
+	for i := range List {
+		hit, err := DecodeHit[T](&List[i])
+		if err != nil {
+			logger.WithField("id", List[i].Id).Error(err)
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+// Pages fetches all hits of the query like ExecuteAll and yields them page
+// by page, decoded into T. An error ends the iteration after it has been
+// yielded. Leaving the loop early stops paging and clears the point in time
+// or scroll context. Pages of parallel slices are yielded one at a time.
+func Pages[T any](ctx context.Context, Gobana *Gobana) iter.Seq2[[]Hit[T], error] {
+	return func(yield func([]Hit[T], error) bool) {
+		stopped := false
+		err := Gobana.ExecuteAll(ctx, func(page *ElasticsearchResult) error {
+			if stopped {
+				return errStopIteration
+			}
+			hits, err := DecodeHits[T](page)
+			if err != nil {
+				return err
+			}
+			if !yield(hits, nil) {
+				stopped = true
+				return errStopIteration
+			}
+			return nil
+		})
+		if err != nil && !stopped {
+			yield(nil, err)
+		}
+	}
+}
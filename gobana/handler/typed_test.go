@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"testing"
+)
+
+type testOrder struct {
+	Customer string     `json:"customer"`
+	Total    int64      `json:"total"`
+	Items    []testItem `json:"items"`
+}
+
+type testItem struct {
+	Sku      string `json:"sku"`
+	Quantity int    `json:"quantity"`
+}
+
+func TestDecodeHits(t *testing.T) {
+	result := readResult(t, "es8_inner_hits.json")
+	hits, err := DecodeHits[testOrder](result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 {
+		t.Fatalf("len(hits) = %d, want 1", len(hits))
+	}
+	hit := hits[0]
+	if hit.Id != "order-1001" || hit.Index != "orders" {
+		t.Errorf("Id, Index = %q, %q, want order-1001, orders", hit.Id, hit.Index)
+	}
+	if hit.Source.Customer != "Smith" {
+		t.Errorf("Source.Customer = %q, want Smith", hit.Source.Customer)
+	}
+	if hit.Source.Total != 9007199254740993 {
+		t.Errorf("Source.Total = %d, want 9007199254740993", hit.Source.Total)
+	}
+	if len(hit.Source.Items) != 2 {
+		t.Errorf("len(Source.Items) = %d, want 2", len(hit.Source.Items))
+	}
+	if len(hit.Sort) != 2 || hit.Sort[1] != "order-1001" {
+		t.Errorf("Sort = %v", hit.Sort)
+	}
+	if got := hit.Highlight["customer"]; len(got) != 1 || got[0] != "<em>Smith</em>" {
+		t.Errorf("Highlight[customer] = %v", got)
+	}
+
+	items, err := DecodeInnerHits[testItem](&result.Hits.Hits[0], "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Source.Sku != "B-7" || items[0].Source.Quantity != 1 {
+		t.Errorf("inner hits = %+v", items)
+	}
+	missing, err := DecodeInnerHits[testItem](&result.Hits.Hits[0], "missing")
+	if err != nil || missing != nil {
+		t.Errorf("DecodeInnerHits(missing) = %v, %v, want nil, nil", missing, err)
+	}
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Watch modes: redraw replaces the output of the previous run, append
+// only outputs new hits and changed aggregations.
+const (
+	WatchRedraw = "redraw"
+	WatchAppend = "append"
+)
+
+// WatchModes lists all watch modes.
+var WatchModes = []string{WatchRedraw, WatchAppend}
+
+// DefaultMaxBackoff limits the delay after failed runs of a watch.
+const DefaultMaxBackoff = 5 * time.Minute
+
+// Highlighting of changed words, reverse video like watch -d.
+const (
+	HighlightOn  = "\x1b[7m"
+	HighlightOff = "\x1b[0m"
+)
+
+// WatchRun is called by Watch for every run.
+type WatchRun func(ctx context.Context) error
+
+// WatchOptions configures Watch. After a failed run, OnError is called with
+// the error and the delay until the next run. If it returns false, the
+// watch ends with the error. Without OnError, all failed runs are retried.
+type WatchOptions struct {
+	Interval   time.Duration
+	MaxBackoff time.Duration
+	OnError    func(Err error, Delay time.Duration) bool
+}
+
+// Watch calls run at once and then Interval after the end of every run,
+// until ctx is cancelled, which ends the watch without error. After
+// failed runs, the delay doubles up to MaxBackoff (DefaultMaxBackoff if
+// zero) and is reset by the next successful run.
+func Watch(ctx context.Context, Options WatchOptions, run WatchRun) error {
+	logger := log.WithField("func", "Watch")
+
+	if Options.Interval <= 0 {
+		err := errors.New("The watch interval must be positive")
+		logger.Error(err)
+		return err
+	}
+	failures := 0
+	for {
+		err := run(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		delay := Options.Interval
+		if err != nil {
+			failures++
+			delay = watchDelay(Options.Interval, Options.MaxBackoff, failures)
+			logger.WithFields(log.Fields{"failures": failures, "delay": delay}).Warn(err)
+			if Options.OnError != nil && !Options.OnError(err, delay) {
+				return err
+			}
+		} else {
+			failures = 0
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// watchDelay returns the delay after the number of Failures in a row.
+func watchDelay(Interval time.Duration, MaxBackoff time.Duration, Failures int) time.Duration {
+	if MaxBackoff <= 0 {
+		MaxBackoff = DefaultMaxBackoff
+	}
+	if MaxBackoff < Interval {
+		MaxBackoff = Interval
+	}
+	delay := Interval
+	for i := 0; i < Failures && delay < MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > MaxBackoff {
+		delay = MaxBackoff
+	}
+	return delay
+}
+
+var wordPattern = regexp.MustCompile(`\s+|\S+`)
+
+// HighlightChanges compares the output of two runs line by line and
+// highlights the words of Current which differ from the same line of
+// Previous, e.g. changed counts in a table. Lines with a different number
+// of words and lines added to the end are highlighted completely.
+func HighlightChanges(Previous string, Current string) string {
+	previous := strings.Split(Previous, "\n")
+	current := strings.Split(Current, "\n")
+	var b strings.Builder
+	for i, line := range current {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		switch {
+		case i < len(previous) && line == previous[i]:
+			b.WriteString(line)
+		case i >= len(previous) || strings.TrimSpace(line) == "":
+			writeHighlighted(&b, line)
+		default:
+			highlightWords(&b, previous[i], line)
+		}
+	}
+	return b.String()
+}
+
+func highlightWords(b *strings.Builder, Previous string, Current string) {
+	previous := wordPattern.FindAllString(Previous, -1)
+	current := wordPattern.FindAllString(Current, -1)
+	if len(previous) != len(current) {
+		writeHighlighted(b, Current)
+		return
+	}
+	for i, word := range current {
+		if word == previous[i] || strings.TrimSpace(word) == "" {
+			b.WriteString(word)
+		} else {
+			writeHighlighted(b, word)
+		}
+	}
+}
+
+func writeHighlighted(b *strings.Builder, Text string) {
+	if Text == "" {
+		return
+	}
+	b.WriteString(HighlightOn)
+	b.WriteString(Text)
+	b.WriteString(HighlightOff)
+}
+
+// Key returns the index and id of the hit, which identify it in results of
+// different runs.
+func (hit *ElasticsearchHitList) Key() string {
+	return hit.Index + "/" + hit.Id
+}
+
+// HitKeys returns the keys of all hits, see ElasticsearchHitList.Key.
+func (result *ElasticsearchResult) HitKeys() map[string]bool {
+	keys := make(map[string]bool, len(result.Hits.Hits))
+	for i := range result.Hits.Hits {
+		keys[result.Hits.Hits[i].Key()] = true
+	}
+	return keys
+}
+
+// WithoutHits returns a copy of the result without the hits whose keys are
+// in Seen.
+func (result *ElasticsearchResult) WithoutHits(Seen map[string]bool) *ElasticsearchResult {
+	filtered := *result
+	filtered.Hits.Hits = nil
+	for _, hit := range result.Hits.Hits {
+		if !Seen[hit.Key()] {
+			filtered.Hits.Hits = append(filtered.Hits.Hits, hit)
+		}
+	}
+	return &filtered
+}
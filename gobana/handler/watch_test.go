@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchDelay(t *testing.T) {
+	tests := []struct {
+		interval   time.Duration
+		maxBackoff time.Duration
+		failures   int
+		want       time.Duration
+	}{
+		{30 * time.Second, 0, 1, time.Minute},
+		{30 * time.Second, 0, 3, 4 * time.Minute},
+		{30 * time.Second, 0, 10, DefaultMaxBackoff},
+		{10 * time.Minute, 0, 2, 10 * time.Minute},
+		{time.Second, 3 * time.Second, 5, 3 * time.Second},
+	}
+	for _, test := range tests {
+		if got := watchDelay(test.interval, test.maxBackoff, test.failures); got != test.want {
+			t.Errorf("watchDelay(%s, %s, %d) = %s, want %s", test.interval, test.maxBackoff, test.failures, got, test.want)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := 0
+	err := Watch(ctx, WatchOptions{Interval: time.Millisecond}, func(ctx context.Context) error {
+		runs++
+		if runs == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil || runs != 3 {
+		t.Errorf("Watch = %v after %d runs, want nil after 3 runs", err, runs)
+	}
+
+	failed := errors.New("failed")
+	var delays []time.Duration
+	err = Watch(context.Background(), WatchOptions{
+		Interval:   time.Millisecond,
+		MaxBackoff: 4 * time.Millisecond,
+		OnError: func(Err error, Delay time.Duration) bool {
+			delays = append(delays, Delay)
+			return len(delays) < 4
+		},
+	}, func(ctx context.Context) error { return failed })
+	if err != failed {
+		t.Errorf("Watch = %v, want %v", err, failed)
+	}
+	want := []time.Duration{2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond}
+	if !reflect.DeepEqual(delays, want) {
+		t.Errorf("delays = %v, want %v", delays, want)
+	}
+}
+
+func TestHighlightChanges(t *testing.T) {
+	h := func(s string) string { return HighlightOn + s + HighlightOff }
+	tests := []struct {
+		name     string
+		previous string
+		current  string
+		want     string
+	}{
+		{"unchanged", "web1  12\n", "web1  12\n", "web1  12\n"},
+		{"changed value", "host  count\nweb1  12\nweb2  3\n", "host  count\nweb1  15\nweb2  3\n",
+			"host  count\nweb1  " + h("15") + "\nweb2  3\n"},
+		{"realigned", "web1  9\n", "web1   10\n", "web1   " + h("10") + "\n"},
+		{"new line", "web1  12\n", "web1  12\nweb3  1\n", "web1  12\n" + h("web3  1") + "\n"},
+		{"different words", "a b\n", "a b c\n", h("a b c") + "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := HighlightChanges(test.previous, test.current); got != test.want {
+				t.Errorf("HighlightChanges = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithoutHits(t *testing.T) {
+	result := &ElasticsearchResult{Hits: ElasticsearchHitResult{Hits: []ElasticsearchHitList{
+		{Index: "logs", Id: "1"}, {Index: "logs", Id: "2"}, {Index: "other", Id: "1"},
+	}}}
+	seen := map[string]bool{"logs/1": true}
+	got := result.WithoutHits(seen)
+	if len(got.Hits.Hits) != 2 || got.Hits.Hits[0].Id != "2" || got.Hits.Hits[1].Index != "other" {
+		t.Errorf("WithoutHits = %v, want logs/2 and other/1", got.Hits.Hits)
+	}
+	if len(result.Hits.Hits) != 3 {
+		t.Errorf("WithoutHits changed the result")
+	}
+	if keys := result.HitKeys(); len(keys) != 3 || !keys["other/1"] {
+		t.Errorf("HitKeys = %v", keys)
+	}
+}
@@ -0,0 +1,134 @@
+package query
+
+// Aggregation is an aggregation with its parameters and sub aggregations.
+type Aggregation struct {
+	kind   string
+	params map[string]interface{}
+	subs   map[string]*Aggregation
+}
+
+// NewAggregation returns an aggregation of the type, e.g. "terms" or
+// "avg", without parameters.
+func NewAggregation(Type string) *Aggregation {
+	return &Aggregation{kind: Type, params: make(map[string]interface{})}
+}
+
+// TermsAggregation returns a terms aggregation with one bucket for each of
+// the Size most frequent values of the field. Size 0 uses the default.
+func TermsAggregation(Field string, Size int) *Aggregation {
+	a := NewAggregation("terms").Param("field", Field)
+	if Size > 0 {
+		a.Param("size", Size)
+	}
+	return a
+}
+
+// Histogram returns a histogram aggregation with buckets of the interval.
+func Histogram(Field string, Interval float64) *Aggregation {
+	return NewAggregation("histogram").Param("field", Field).Param("interval", Interval)
+}
+
+// DateHistogram returns a date_histogram aggregation. Calendar units like
+// "1d", "week" or "month" use calendar_interval, other intervals like
+// "12h" fixed_interval.
+func DateHistogram(Field string, Interval string) *Aggregation {
+	a := NewAggregation("date_histogram").Param("field", Field)
+	if calendarIntervals[Interval] {
+		return a.Param("calendar_interval", Interval)
+	}
+	return a.Param("fixed_interval", Interval)
+}
+
+var calendarIntervals = map[string]bool{
+	"minute": true, "1m": true,
+	"hour": true, "1h": true,
+	"day": true, "1d": true,
+	"week": true, "1w": true,
+	"month": true, "1M": true,
+	"quarter": true, "1q": true,
+	"year": true, "1y": true,
+}
+
+// RangeAggregation returns a range aggregation with one bucket per range.
+// Use nil for an open bound.
+func RangeAggregation(Field string, Ranges ...[2]interface{}) *Aggregation {
+	ranges := make([]interface{}, 0, len(Ranges))
+	for _, r := range Ranges {
+		bounds := make(map[string]interface{})
+		if r[0] != nil {
+			bounds["from"] = r[0]
+		}
+		if r[1] != nil {
+			bounds["to"] = r[1]
+		}
+		ranges = append(ranges, bounds)
+	}
+	return NewAggregation("range").Param("field", Field).Param("ranges", ranges)
+}
+
+// FilterAggregation returns a single bucket aggregation of the documents
+// matching the query.
+func FilterAggregation(Query Query) *Aggregation {
+	return &Aggregation{kind: "filter", params: Query.Source()}
+}
+
+// Composite returns a composite aggregation with one terms source per
+// field, named like the field.
+func Composite(Size int, Fields ...string) *Aggregation {
+	sources := make([]interface{}, 0, len(Fields))
+	for _, field := range Fields {
+		sources = append(sources, map[string]interface{}{
+			field: map[string]interface{}{"terms": map[string]interface{}{"field": field}},
+		})
+	}
+	a := NewAggregation("composite").Param("sources", sources)
+	if Size > 0 {
+		a.Param("size", Size)
+	}
+	return a
+}
+
+// Metric returns a metric aggregation of the type (avg, sum, min, max,
+// cardinality, value_count, stats, extended_stats, percentiles) on the
+// field.
+func Metric(Type string, Field string) *Aggregation {
+	return NewAggregation(Type).Param("field", Field)
+}
+
+// TopHits returns a top_hits aggregation with the Size best hits of each
+// bucket.
+func TopHits(Size int) *Aggregation {
+	return NewAggregation("top_hits").Param("size", Size)
+}
+
+// Param sets a parameter of the aggregation.
+func (a *Aggregation) Param(Key string, Value interface{}) *Aggregation {
+	a.params[Key] = Value
+	return a
+}
+
+// SubAggregation adds a sub aggregation.
+func (a *Aggregation) SubAggregation(Name string, Sub *Aggregation) *Aggregation {
+	if a.subs == nil {
+		a.subs = make(map[string]*Aggregation)
+	}
+	a.subs[Name] = Sub
+	return a
+}
+
+// Source returns the aggregation.
+func (a *Aggregation) Source() map[string]interface{} {
+	source := map[string]interface{}{a.kind: a.params}
+	if len(a.subs) > 0 {
+		source["aggs"] = aggregationsSource(a.subs)
+	}
+	return source
+}
+
+func aggregationsSource(Aggregations map[string]*Aggregation) map[string]interface{} {
+	source := make(map[string]interface{}, len(Aggregations))
+	for name, a := range Aggregations {
+		source[name] = a.Source()
+	}
+	return source
+}
@@ -0,0 +1,402 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// KQL translates a query in the Kibana query language into the query DSL
+// the way Kibana does:
+//
+//	status:404                  match
+//	message:"connection reset"  match_phrase
+//	host.name:web*              query_string on the field
+//	error:*                     exists
+//	latency >= 100              range (<, <=, >, >=)
+//	timeout                     multi_match on all fields
+//	a and b, a or b, not a      bool filter, should, must_not
+//	status:(404 or 500)         values for one field
+//	items:{ sku:A-1 }           nested query
+//
+// Special characters \():<>"*{} and the keywords or, and, not are escaped
+// with a backslash. An empty query matches all documents.
+func KQL(Text string) (Query, error) {
+	p := &kqlParser{input: []rune(Text)}
+	p.skipSpace()
+	if p.done() {
+		return MatchAll(), nil
+	}
+	q, err := p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.done() {
+		return nil, p.errorf("unexpected '%c'", p.peek())
+	}
+	return q, nil
+}
+
+// kqlParser is a recursive descent parser for KQL. Methods taking a field
+// parse the values of a field inside "field:( ... )".
+type kqlParser struct {
+	input  []rune
+	pos    int
+	nested string
+}
+
+// kqlValue is a value of a KQL query. Text is the unescaped value, Pattern
+// the value for a query_string query with unescaped wildcards kept.
+type kqlValue struct {
+	text     string
+	pattern  string
+	quoted   bool
+	wildcard bool
+}
+
+const kqlSpecial = `\():<>"*{}`
+
+func (p *kqlParser) parseOr(Field string) (Query, error) {
+	var clauses []Query
+	for {
+		q, err := p.parseAnd(Field)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, q)
+		if !p.keyword("or") {
+			break
+		}
+	}
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return Bool().Should(clauses...).MinimumShouldMatch(1), nil
+}
+
+func (p *kqlParser) parseAnd(Field string) (Query, error) {
+	var clauses []Query
+	for {
+		q, err := p.parseNot(Field)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, q)
+		if !p.keyword("and") {
+			break
+		}
+	}
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return Bool().Filter(clauses...), nil
+}
+
+func (p *kqlParser) parseNot(Field string) (Query, error) {
+	if p.keyword("not") {
+		q, err := p.parseNot(Field)
+		if err != nil {
+			return nil, err
+		}
+		return Bool().MustNot(q), nil
+	}
+	return p.parsePrimary(Field)
+}
+
+func (p *kqlParser) parsePrimary(Field string) (Query, error) {
+	p.skipSpace()
+	if p.done() {
+		return nil, p.errorf("unexpected end of query")
+	}
+	if p.peek() == '(' {
+		p.pos++
+		q, err := p.parseOr(Field)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return q, nil
+	}
+	if Field != "" {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return fieldQuery(Field, value), nil
+	}
+	if p.peek() == '"' {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return textQuery(value), nil
+	}
+	name := p.readLiteral()
+	p.skipSpace()
+	if name.text == "" {
+		return nil, p.errorf("unexpected '%c'", p.peek())
+	}
+	if p.done() {
+		return textQuery(name), nil
+	}
+	field := p.nested + name.text
+	switch p.peek() {
+	case ':':
+		p.pos++
+		p.skipSpace()
+		if p.done() {
+			return nil, p.errorf("missing value for field '%s'", name.text)
+		}
+		switch p.peek() {
+		case '(':
+			return p.parsePrimary(field)
+		case '{':
+			return p.parseNested(field)
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return fieldQuery(field, value), nil
+	case '<', '>':
+		op := string(p.peek())
+		p.pos++
+		if !p.done() && p.peek() == '=' {
+			op += "="
+			p.pos++
+		}
+		p.skipSpace()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if name.wildcard {
+			return nil, errors.New("KQL: range on wildcard field '" + name.text + "' is not supported")
+		}
+		return rangeQuery(field, op, value.text), nil
+	}
+	if p.peek() == '{' || p.peek() == '}' || p.peek() == '"' {
+		return nil, p.errorf("unexpected '%c'", p.peek())
+	}
+	return textQuery(name), nil
+}
+
+// parseNested parses "field:{ query }" into a nested query on the path.
+func (p *kqlParser) parseNested(Path string) (Query, error) {
+	p.pos++
+	outer := p.nested
+	p.nested = Path + "."
+	q, err := p.parseOr("")
+	p.nested = outer
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect('}'); err != nil {
+		return nil, err
+	}
+	return Clause{"nested": map[string]interface{}{
+		"path":       Path,
+		"query":      q.Source(),
+		"score_mode": "none",
+	}}, nil
+}
+
+func (p *kqlParser) parseValue() (kqlValue, error) {
+	p.skipSpace()
+	if p.done() {
+		return kqlValue{}, p.errorf("missing value")
+	}
+	if p.peek() != '"' {
+		value := p.readLiteral()
+		if value.text == "" {
+			return value, p.errorf("unexpected '%c'", p.peek())
+		}
+		return value, nil
+	}
+	p.pos++
+	var b strings.Builder
+	for !p.done() {
+		r := p.input[p.pos]
+		p.pos++
+		switch r {
+		case '"':
+			text := b.String()
+			return kqlValue{text: text, pattern: Escape(text), quoted: true}, nil
+		case '\\':
+			if p.done() {
+				return kqlValue{}, p.errorf("incomplete escape sequence")
+			}
+			r = p.input[p.pos]
+			p.pos++
+		}
+		b.WriteRune(r)
+	}
+	return kqlValue{}, p.errorf("missing closing '\"'")
+}
+
+// readLiteral reads an unquoted value, which may contain whitespace, up to
+// the next special character or the keywords or and and. Trailing
+// whitespace is dropped.
+func (p *kqlParser) readLiteral() kqlValue {
+	var text, pattern strings.Builder
+	var textEnd, patternEnd int
+	value := kqlValue{}
+	for !p.done() {
+		r := p.input[p.pos]
+		switch {
+		case unicode.IsSpace(r) && (p.keywordAt(p.pos, "or") || p.keywordAt(p.pos, "and")):
+		case r == '\\' && p.pos+1 < len(p.input):
+			r = p.input[p.pos+1]
+			p.pos += 2
+			text.WriteRune(r)
+			pattern.WriteString(escapePattern(r))
+			textEnd, patternEnd = text.Len(), pattern.Len()
+			continue
+		case r == '*':
+			value.wildcard = true
+			text.WriteRune(r)
+			pattern.WriteRune(r)
+			p.pos++
+			textEnd, patternEnd = text.Len(), pattern.Len()
+			continue
+		case !strings.ContainsRune(kqlSpecial, r):
+			text.WriteRune(r)
+			pattern.WriteString(escapePattern(r))
+			p.pos++
+			if !unicode.IsSpace(r) {
+				textEnd, patternEnd = text.Len(), pattern.Len()
+			}
+			continue
+		}
+		break
+	}
+	value.text = text.String()[:textEnd]
+	value.pattern = pattern.String()[:patternEnd]
+	return value
+}
+
+// escapePattern escapes a character for a query_string pattern, including
+// whitespace which would split the pattern into terms.
+func escapePattern(R rune) string {
+	if unicode.IsSpace(R) {
+		return `\` + string(R)
+	}
+	if R == '*' {
+		return `\*`
+	}
+	return Escape(string(R))
+}
+
+// keyword consumes the keyword and returns true if it is next in the
+// input. The keywords or and and must be preceded by whitespace.
+func (p *kqlParser) keyword(Keyword string) bool {
+	if Keyword == "not" {
+		p.skipSpace()
+		if p.matchWord(p.pos, Keyword) {
+			p.pos += len(Keyword)
+			return true
+		}
+		return false
+	}
+	if !p.keywordAt(p.pos, Keyword) {
+		return false
+	}
+	p.skipSpace()
+	p.pos += len(Keyword)
+	return true
+}
+
+// keywordAt returns true if whitespace and the keyword start at Pos.
+func (p *kqlParser) keywordAt(Pos int, Keyword string) bool {
+	if Pos >= len(p.input) || !unicode.IsSpace(p.input[Pos]) {
+		return false
+	}
+	for Pos < len(p.input) && unicode.IsSpace(p.input[Pos]) {
+		Pos++
+	}
+	return p.matchWord(Pos, Keyword)
+}
+
+// matchWord returns true if the keyword starts at Pos, followed by
+// whitespace, "(" or the end of the query.
+func (p *kqlParser) matchWord(Pos int, Keyword string) bool {
+	end := Pos + len(Keyword)
+	if end > len(p.input) || !strings.EqualFold(string(p.input[Pos:end]), Keyword) {
+		return false
+	}
+	return end == len(p.input) || unicode.IsSpace(p.input[end]) || p.input[end] == '('
+}
+
+func (p *kqlParser) expect(R rune) error {
+	p.skipSpace()
+	if p.done() || p.peek() != R {
+		return p.errorf("missing '%c'", R)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *kqlParser) skipSpace() {
+	for !p.done() && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *kqlParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *kqlParser) peek() rune {
+	return p.input[p.pos]
+}
+
+func (p *kqlParser) errorf(Format string, Args ...interface{}) error {
+	return fmt.Errorf("KQL: %s at position %d", fmt.Sprintf(Format, Args...), p.pos+1)
+}
+
+// fieldQuery returns the query for "field:value".
+func fieldQuery(Field string, Value kqlValue) Query {
+	switch {
+	case !Value.quoted && Value.text == "*":
+		return Exists(Field)
+	case Value.wildcard:
+		return QueryString(Value.pattern).Fields(Field).AnalyzeWildcard(true)
+	case strings.Contains(Field, "*") && Value.quoted:
+		return MultiMatch(Value.text, "phrase", Field)
+	case strings.Contains(Field, "*"):
+		return MultiMatch(Value.text, "best_fields", Field)
+	case Value.quoted:
+		return MatchPhrase(Field, Value.text)
+	}
+	return Match(Field, Value.text)
+}
+
+// textQuery returns the query for a value without field.
+func textQuery(Value kqlValue) Query {
+	switch {
+	case Value.wildcard:
+		return QueryString(Value.pattern).AnalyzeWildcard(true)
+	case Value.quoted:
+		return MultiMatch(Value.text, "phrase")
+	}
+	return MultiMatch(Value.text, "best_fields")
+}
+
+func rangeQuery(Field string, Op string, Value string) Query {
+	q := Range(Field)
+	switch Op {
+	case "<":
+		q.Lt(Value)
+	case "<=":
+		q.Lte(Value)
+	case ">":
+		q.Gt(Value)
+	case ">=":
+		q.Gte(Value)
+	}
+	return q
+}
@@ -0,0 +1,66 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestKQL(t *testing.T) {
+	tests := []struct {
+		kql  string
+		want string
+	}{
+		{"", `{"match_all":{}}`},
+		{"status:404", `{"match":{"status":"404"}}`},
+		{`message:"connection reset"`, `{"match_phrase":{"message":"connection reset"}}`},
+		{"message:quick brown fox", `{"match":{"message":"quick brown fox"}}`},
+		{"error:*", `{"exists":{"field":"error"}}`},
+		{"host.name:web-*", `{"query_string":{"analyze_wildcard":true,"fields":["host.name"],"query":"web\\-*"}}`},
+		{"latency >= 100", `{"range":{"latency":{"gte":"100"}}}`},
+		{"@timestamp < now-1d", `{"range":{"@timestamp":{"lt":"now-1d"}}}`},
+		{"timeout", `{"multi_match":{"lenient":true,"query":"timeout","type":"best_fields"}}`},
+		{`"read timeout"`, `{"multi_match":{"lenient":true,"query":"read timeout","type":"phrase"}}`},
+		{"status:404 and not method:GET",
+			`{"bool":{"filter":[{"match":{"status":"404"}},{"bool":{"must_not":[{"match":{"method":"GET"}}]}}]}}`},
+		{"a:1 or b:2 and c:3",
+			`{"bool":{"minimum_should_match":1,"should":[{"match":{"a":"1"}},{"bool":{"filter":[{"match":{"b":"2"}},{"match":{"c":"3"}}]}}]}}`},
+		{"(a:1 OR b:2) AND c:3",
+			`{"bool":{"filter":[{"bool":{"minimum_should_match":1,"should":[{"match":{"a":"1"}},{"match":{"b":"2"}}]}},{"match":{"c":"3"}}]}}`},
+		{"status:(404 or 500)",
+			`{"bool":{"minimum_should_match":1,"should":[{"match":{"status":"404"}},{"match":{"status":"500"}}]}}`},
+		{"items:{ sku:A-1 and quantity > 1 }",
+			`{"nested":{"path":"items","query":{"bool":{"filter":[{"match":{"items.sku":"A-1"}},{"range":{"items.quantity":{"gt":"1"}}}]}},"score_mode":"none"}}`},
+		{`path:C\:\\temp`, `{"match":{"path":"C:\\temp"}}`},
+		{`title:rock \and roll`, `{"match":{"title":"rock and roll"}}`},
+		{"host.*:web", `{"multi_match":{"fields":["host.*"],"lenient":true,"query":"web","type":"best_fields"}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.kql, func(t *testing.T) {
+			q, err := KQL(test.kql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := json.Marshal(q.Source())
+			if string(data) != test.want {
+				t.Errorf("got  %s\nwant %s", data, test.want)
+			}
+		})
+	}
+}
+
+func TestKQLErrors(t *testing.T) {
+	for _, kql := range []string{
+		"status:",
+		"(a:1",
+		`message:"open`,
+		"a:1 b:2",
+		"a:1 and",
+		"items:{ sku:A-1",
+		"host.*>1",
+	} {
+		if q, err := KQL(kql); err == nil {
+			data, _ := json.Marshal(q.Source())
+			t.Errorf("KQL(%q) = %s, want error", kql, data)
+		}
+	}
+}
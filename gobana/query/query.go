@@ -0,0 +1,263 @@
+// Package query builds request bodies for the Elasticsearch search DSL. The
+// builders produce maps which are encoded with encoding/json, so values are
+// always escaped properly.
+package query
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Query is a clause of the query DSL.
+type Query interface {
+	Source() map[string]interface{}
+}
+
+// Clause is a query clause given as map, e.g. decoded from json.
+type Clause map[string]interface{}
+
+// Source returns the clause.
+func (clause Clause) Source() map[string]interface{} {
+	return clause
+}
+
+// Raw decodes a query clause from json, e.g. a query given by the user.
+func Raw(Data []byte) (Clause, error) {
+	var clause Clause
+	err := json.Unmarshal(Data, &clause)
+	if err != nil {
+		return nil, err
+	}
+	return clause, nil
+}
+
+// MatchAll matches all documents.
+func MatchAll() Clause {
+	return Clause{"match_all": map[string]interface{}{}}
+}
+
+// Term matches documents with the exact value in the field.
+func Term(Field string, Value interface{}) Clause {
+	return Clause{"term": map[string]interface{}{Field: Value}}
+}
+
+// Terms matches documents with one of the exact values in the field.
+func Terms(Field string, Values ...interface{}) Clause {
+	if Values == nil {
+		Values = []interface{}{}
+	}
+	return Clause{"terms": map[string]interface{}{Field: Values}}
+}
+
+// Match is a full text query on the field.
+func Match(Field string, Text interface{}) Clause {
+	return Clause{"match": map[string]interface{}{Field: Text}}
+}
+
+// MatchPhrase matches the words of Text in the field in the same order.
+func MatchPhrase(Field string, Text string) Clause {
+	return Clause{"match_phrase": map[string]interface{}{Field: Text}}
+}
+
+// MultiMatch is a full text query on the fields, all fields if none are
+// given. Type is e.g. "best_fields" or "phrase". Fields which can't be
+// searched with the text, e.g. numbers, are skipped.
+func MultiMatch(Text string, Type string, Fields ...string) Clause {
+	params := map[string]interface{}{"query": Text, "type": Type, "lenient": true}
+	if len(Fields) > 0 {
+		params["fields"] = Fields
+	}
+	return Clause{"multi_match": params}
+}
+
+// Exists matches documents with a value in the field.
+func Exists(Field string) Clause {
+	return Clause{"exists": map[string]interface{}{"field": Field}}
+}
+
+// Wildcard matches the field against a pattern using * and ?.
+func Wildcard(Field string, Pattern string) Clause {
+	return Clause{"wildcard": map[string]interface{}{Field: Pattern}}
+}
+
+// BoolQuery combines queries.
+type BoolQuery struct {
+	must               []Query
+	filter             []Query
+	should             []Query
+	mustNot            []Query
+	minimumShouldMatch interface{}
+}
+
+// Bool returns an empty bool query, which matches all documents.
+func Bool() *BoolQuery {
+	return new(BoolQuery)
+}
+
+// Must adds queries which must match and contribute to the score.
+func (q *BoolQuery) Must(Queries ...Query) *BoolQuery {
+	q.must = append(q.must, Queries...)
+	return q
+}
+
+// Filter adds queries which must match without scoring.
+func (q *BoolQuery) Filter(Queries ...Query) *BoolQuery {
+	q.filter = append(q.filter, Queries...)
+	return q
+}
+
+// Should adds queries of which at least MinimumShouldMatch should match.
+func (q *BoolQuery) Should(Queries ...Query) *BoolQuery {
+	q.should = append(q.should, Queries...)
+	return q
+}
+
+// MustNot adds queries which must not match.
+func (q *BoolQuery) MustNot(Queries ...Query) *BoolQuery {
+	q.mustNot = append(q.mustNot, Queries...)
+	return q
+}
+
+// MinimumShouldMatch sets the number (e.g. 1) or percentage (e.g. "75%")
+// of should clauses which must match.
+func (q *BoolQuery) MinimumShouldMatch(Value interface{}) *BoolQuery {
+	q.minimumShouldMatch = Value
+	return q
+}
+
+// Source returns the bool query.
+func (q *BoolQuery) Source() map[string]interface{} {
+	b := make(map[string]interface{})
+	addClauses(b, "must", q.must)
+	addClauses(b, "filter", q.filter)
+	addClauses(b, "should", q.should)
+	addClauses(b, "must_not", q.mustNot)
+	if q.minimumShouldMatch != nil {
+		b["minimum_should_match"] = q.minimumShouldMatch
+	}
+	return map[string]interface{}{"bool": b}
+}
+
+func addClauses(Bool map[string]interface{}, Name string, Queries []Query) {
+	if len(Queries) == 0 {
+		return
+	}
+	clauses := make([]interface{}, 0, len(Queries))
+	for _, q := range Queries {
+		clauses = append(clauses, q.Source())
+	}
+	Bool[Name] = clauses
+}
+
+// RangeQuery matches documents with values in a range.
+type RangeQuery struct {
+	field  string
+	params map[string]interface{}
+}
+
+// Range returns a range query on the field without bounds.
+func Range(Field string) *RangeQuery {
+	return &RangeQuery{field: Field, params: make(map[string]interface{})}
+}
+
+// Gte sets the lower bound including the value.
+func (q *RangeQuery) Gte(Value interface{}) *RangeQuery {
+	q.params["gte"] = Value
+	return q
+}
+
+// Gt sets the lower bound excluding the value.
+func (q *RangeQuery) Gt(Value interface{}) *RangeQuery {
+	q.params["gt"] = Value
+	return q
+}
+
+// Lte sets the upper bound including the value.
+func (q *RangeQuery) Lte(Value interface{}) *RangeQuery {
+	q.params["lte"] = Value
+	return q
+}
+
+// Lt sets the upper bound excluding the value.
+func (q *RangeQuery) Lt(Value interface{}) *RangeQuery {
+	q.params["lt"] = Value
+	return q
+}
+
+// Format sets the date format of the bounds.
+func (q *RangeQuery) Format(Format string) *RangeQuery {
+	q.params["format"] = Format
+	return q
+}
+
+// TimeZone sets the time zone of date bounds without time zone, e.g.
+// "Europe/Berlin" or "+01:00".
+func (q *RangeQuery) TimeZone(TimeZone string) *RangeQuery {
+	q.params["time_zone"] = TimeZone
+	return q
+}
+
+// Source returns the range query.
+func (q *RangeQuery) Source() map[string]interface{} {
+	return map[string]interface{}{"range": map[string]interface{}{q.field: q.params}}
+}
+
+// QueryStringQuery is a query in the Lucene query syntax.
+type QueryStringQuery struct {
+	params map[string]interface{}
+}
+
+// QueryString returns a query_string query. Use Escape for user input
+// which should be searched literally.
+func QueryString(Query string) *QueryStringQuery {
+	return &QueryStringQuery{params: map[string]interface{}{"query": Query}}
+}
+
+// DefaultField sets the field searched by terms without field.
+func (q *QueryStringQuery) DefaultField(Field string) *QueryStringQuery {
+	q.params["default_field"] = Field
+	return q
+}
+
+// Fields sets the fields searched by terms without field.
+func (q *QueryStringQuery) Fields(Fields ...string) *QueryStringQuery {
+	q.params["fields"] = Fields
+	return q
+}
+
+// DefaultOperator sets the operator between terms, "OR" (default) or
+// "AND".
+func (q *QueryStringQuery) DefaultOperator(Operator string) *QueryStringQuery {
+	q.params["default_operator"] = strings.ToUpper(Operator)
+	return q
+}
+
+// AnalyzeWildcard enables the analysis of terms with wildcards.
+func (q *QueryStringQuery) AnalyzeWildcard(Analyze bool) *QueryStringQuery {
+	q.params["analyze_wildcard"] = Analyze
+	return q
+}
+
+// Source returns the query_string query.
+func (q *QueryStringQuery) Source() map[string]interface{} {
+	return map[string]interface{}{"query_string": q.params}
+}
+
+// queryStringReserved are the characters escaped by Escape.
+var queryStringReserved = `+-=&|!(){}[]^"~*?:\/`
+
+// Escape escapes the reserved characters of the query_string syntax, so
+// Text is searched literally. "<" and ">" can't be escaped and are removed.
+func Escape(Text string) string {
+	var b strings.Builder
+	for _, r := range Text {
+		switch {
+		case r == '<' || r == '>':
+			continue
+		case strings.ContainsRune(queryStringReserved, r):
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
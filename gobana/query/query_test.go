@@ -0,0 +1,91 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"match_all", MatchAll(), `{"match_all":{}}`},
+		{"term", Term("host.name", "web-1"), `{"term":{"host.name":"web-1"}}`},
+		{"terms", Terms("status", 404, 500), `{"terms":{"status":[404,500]}}`},
+		{"match escapes", Match("message", `say "hi"`), `{"match":{"message":"say \"hi\""}}`},
+		{"exists", Exists("error"), `{"exists":{"field":"error"}}`},
+		{"range", Range("@timestamp").Gte("now-15m").Lt("now").TimeZone("Europe/Berlin"),
+			`{"range":{"@timestamp":{"gte":"now-15m","lt":"now","time_zone":"Europe/Berlin"}}}`},
+		{"query_string", QueryString("status:404").DefaultOperator("and"),
+			`{"query_string":{"default_operator":"AND","query":"status:404"}}`},
+		{"bool", Bool().Filter(Term("a", 1)).MustNot(Exists("b")).Should(Match("c", "x"), Match("d", "y")).MinimumShouldMatch(1),
+			`{"bool":{"filter":[{"term":{"a":1}}],"minimum_should_match":1,"must_not":[{"exists":{"field":"b"}}],"should":[{"match":{"c":"x"}},{"match":{"d":"y"}}]}}`},
+		{"empty bool", Bool(), `{"bool":{}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := json.Marshal(test.query.Source())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != test.want {
+				t.Errorf("got  %s\nwant %s", data, test.want)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		name   string
+		search *Search
+		want   string
+	}{
+		{"empty", NewSearch(), `{}`},
+		{"full", NewSearch().
+			Query(Bool().Filter(Term("service", "api"), Range("latency").Gt(100))).
+			Size(10).
+			From(20).
+			SortBy("@timestamp:desc", "host.name").
+			Source([]string{"host.*", "latency"}, nil).
+			Aggregation("by_host", TermsAggregation("host.name", 5).
+				SubAggregation("by_day", DateHistogram("@timestamp", "1d").
+					SubAggregation("avg_latency", Metric("avg", "latency")))),
+			`{"_source":{"includes":["host.*","latency"]},` +
+				`"aggs":{"by_host":{"aggs":{"by_day":{"aggs":{"avg_latency":{"avg":{"field":"latency"}}},"date_histogram":{"calendar_interval":"1d","field":"@timestamp"}}},"terms":{"field":"host.name","size":5}}},` +
+				`"from":20,` +
+				`"query":{"bool":{"filter":[{"term":{"service":"api"}},{"range":{"latency":{"gt":100}}}]}},` +
+				`"size":10,` +
+				`"sort":[{"@timestamp":{"order":"desc"}},{"host.name":{"order":"asc"}}]}`},
+		{"no source", NewSearch().NoSource().Size(0).Param("track_total_hits", true),
+			`{"_source":false,"size":0,"track_total_hits":true}`},
+		{"composite", NewSearch().Size(0).Aggregation("pairs", Composite(100, "host", "status")),
+			`{"aggs":{"pairs":{"composite":{"size":100,"sources":[{"host":{"terms":{"field":"host"}}},{"status":{"terms":{"field":"status"}}}]}}},"size":0}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.search.String(); got != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{`a:b (c) "d"`, `a\:b \(c\) \"d\"`},
+		{"path/to/file*", `path\/to\/file\*`},
+		{"x <y> z", "x y z"},
+	}
+	for _, test := range tests {
+		if got := Escape(test.text); got != test.want {
+			t.Errorf("Escape(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
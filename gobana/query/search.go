@@ -0,0 +1,146 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Search is the body of a search request.
+type Search struct {
+	query        Query
+	aggregations map[string]*Aggregation
+	sort         []interface{}
+	source       interface{}
+	size         *int
+	from         *int
+	params       map[string]interface{}
+}
+
+// NewSearch returns an empty search, which matches all documents.
+func NewSearch() *Search {
+	return new(Search)
+}
+
+// Query sets the query.
+func (s *Search) Query(Query Query) *Search {
+	s.query = Query
+	return s
+}
+
+// Size sets the number of hits to return.
+func (s *Search) Size(Size int) *Search {
+	s.size = &Size
+	return s
+}
+
+// From sets the offset of the first hit to return.
+func (s *Search) From(From int) *Search {
+	s.from = &From
+	return s
+}
+
+// Sort adds a sort on the field, Order is "asc" or "desc". The special
+// fields "_score" and "_doc" can be used as well.
+func (s *Search) Sort(Field string, Order string) *Search {
+	s.sort = append(s.sort, map[string]interface{}{
+		Field: map[string]interface{}{"order": strings.ToLower(Order)},
+	})
+	return s
+}
+
+// SortBy parses sort specifications like "@timestamp:desc" or "host.name"
+// (ascending) and adds them.
+func (s *Search) SortBy(Specs ...string) *Search {
+	for _, spec := range Specs {
+		field, order := spec, "asc"
+		if i := strings.LastIndex(spec, ":"); i > 0 {
+			switch o := strings.ToLower(spec[i+1:]); o {
+			case "asc", "desc":
+				field, order = spec[:i], o
+			}
+		}
+		s.Sort(field, order)
+	}
+	return s
+}
+
+// Source restricts the returned _source to the included fields, which may
+// use wildcards. Excluded fields are removed from the included ones.
+func (s *Search) Source(Includes []string, Excludes []string) *Search {
+	source := make(map[string]interface{})
+	if len(Includes) > 0 {
+		source["includes"] = Includes
+	}
+	if len(Excludes) > 0 {
+		source["excludes"] = Excludes
+	}
+	s.source = source
+	return s
+}
+
+// NoSource returns the hits without _source.
+func (s *Search) NoSource() *Search {
+	s.source = false
+	return s
+}
+
+// Aggregation adds a top level aggregation.
+func (s *Search) Aggregation(Name string, Value *Aggregation) *Search {
+	if s.aggregations == nil {
+		s.aggregations = make(map[string]*Aggregation)
+	}
+	s.aggregations[Name] = Value
+	return s
+}
+
+// Param sets any other top level parameter of the body, e.g.
+// "track_total_hits" or "highlight".
+func (s *Search) Param(Key string, Value interface{}) *Search {
+	if s.params == nil {
+		s.params = make(map[string]interface{})
+	}
+	s.params[Key] = Value
+	return s
+}
+
+// Body returns the body of the search request.
+func (s *Search) Body() map[string]interface{} {
+	body := make(map[string]interface{}, len(s.params)+6)
+	for k, v := range s.params {
+		body[k] = v
+	}
+	if s.query != nil {
+		body["query"] = s.query.Source()
+	}
+	if len(s.aggregations) > 0 {
+		body["aggs"] = aggregationsSource(s.aggregations)
+	}
+	if len(s.sort) > 0 {
+		body["sort"] = s.sort
+	}
+	if s.source != nil {
+		body["_source"] = s.source
+	}
+	if s.size != nil {
+		body["size"] = *s.size
+	}
+	if s.from != nil {
+		body["from"] = *s.from
+	}
+	return body
+}
+
+// MarshalJSON encodes the body of the search request.
+func (s *Search) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Body())
+}
+
+// String returns the body of the search request as json, as expected by
+// gobana's query.
+func (s *Search) String() string {
+	data, err := s.MarshalJSON()
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
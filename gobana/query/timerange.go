@@ -0,0 +1,205 @@
+package query
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeField is the date field used by TimeRange if none is set.
+const DefaultTimeField = "@timestamp"
+
+// TimeRange is a range on a date field. From and To accept Elasticsearch
+// date math ("now-30d/d", "2024-11-04||+1M"), absolute times (RFC3339 or
+// without zone, e.g. "2024-11-04T10:00:00" or "2024-11-04") and epoch
+// milliseconds. Last is a duration back from now, e.g. "15m", "7d" or
+// "1h30m", and can't be combined with From. TimeZone (e.g.
+// "Europe/Berlin", "+01:00" or "local") applies to absolute times without
+// zone and to rounding.
+type TimeRange struct {
+	Field    string
+	From     string
+	To       string
+	Last     string
+	TimeZone string
+}
+
+var dateMathPattern = regexp.MustCompile(`^(?:[+-]\d+[yMwdhHms]|/[yMwdhHms])*$`)
+var esDurationPattern = regexp.MustCompile(`^\d+[yMwdhHms]$`)
+var epochPattern = regexp.MustCompile(`^\d+$`)
+
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// IsZero returns true if the range has no bounds.
+func (r TimeRange) IsZero() bool {
+	return r.From == "" && r.To == "" && r.Last == ""
+}
+
+// Query returns the range query for the time range after validating the
+// bounds.
+func (r TimeRange) Query() (*RangeQuery, error) {
+	field := r.Field
+	if field == "" {
+		field = DefaultTimeField
+	}
+	q := Range(field)
+	from, to := r.From, r.To
+	if r.Last != "" {
+		if from != "" {
+			return nil, errors.New("Can't use exclusive time range settings from and last at the same time")
+		}
+		last, err := lastDuration(r.Last)
+		if err != nil {
+			return nil, err
+		}
+		from = "now-" + last
+		if to == "" {
+			to = "now"
+		}
+	}
+	if from != "" {
+		bound, err := DateBound(from)
+		if err != nil {
+			return nil, err
+		}
+		q.Gte(bound)
+	}
+	if to != "" {
+		bound, err := DateBound(to)
+		if err != nil {
+			return nil, err
+		}
+		q.Lte(bound)
+	}
+	if r.TimeZone != "" {
+		zone, err := timeZone(r.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+		q.TimeZone(zone)
+	}
+	return q, nil
+}
+
+// DateBound validates a bound of a date range and returns it in the form
+// expected by Elasticsearch.
+func DateBound(Value string) (string, error) {
+	value := strings.TrimSpace(Value)
+	if strings.HasPrefix(value, "now") && dateMathPattern.MatchString(value[3:]) {
+		return value, nil
+	}
+	anchor, math, anchored := strings.Cut(value, "||")
+	if anchored && !dateMathPattern.MatchString(math) {
+		return "", errors.New("Invalid date math '" + math + "' in '" + Value + "'")
+	}
+	if epochPattern.MatchString(anchor) {
+		return value, nil
+	}
+	// Accept a space between date and time as well.
+	if len(anchor) > 10 && anchor[10] == ' ' {
+		anchor = anchor[:10] + "T" + anchor[11:]
+	}
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, anchor); err == nil {
+			if anchored {
+				return anchor + "||" + math, nil
+			}
+			return anchor, nil
+		}
+	}
+	return "", errors.New("Invalid date '" + Value + "', use date math like now-15m or an RFC3339 time")
+}
+
+// lastDuration converts a duration to date math. Elasticsearch units like
+// "15m" or "7d" are used as they are, other durations like "1h30m" are
+// converted to seconds.
+func lastDuration(Last string) (string, error) {
+	if esDurationPattern.MatchString(Last) {
+		return Last, nil
+	}
+	d, err := time.ParseDuration(Last)
+	if err != nil || d <= 0 {
+		return "", errors.New("Invalid duration '" + Last + "', use e.g. 15m, 2h, 7d or 1h30m")
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10) + "s", nil
+}
+
+// timeZone validates the time zone. "local" is replaced by the name of the
+// local time zone, see localZone.
+func timeZone(Zone string) (string, error) {
+	if strings.EqualFold(Zone, "local") {
+		return localZone(), nil
+	}
+	if _, err := time.Parse("-07:00", Zone); err == nil {
+		return Zone, nil
+	}
+	if _, err := time.LoadLocation(Zone); err != nil {
+		return "", errors.New("Unknown time zone '" + Zone + "'")
+	}
+	return Zone, nil
+}
+
+// localZone returns the IANA name of the local time zone from the TZ
+// environment variable, the /etc/localtime link or /etc/timezone, so
+// Elasticsearch applies daylight saving time. If none is found, the current
+// offset is used.
+func localZone() string {
+	if tz, ok := os.LookupEnv("TZ"); ok {
+		if tz == "" {
+			return "UTC"
+		}
+		if name := zoneName(strings.TrimPrefix(tz, ":")); name != "" {
+			return name
+		}
+	}
+	if link, err := os.Readlink("/etc/localtime"); err == nil {
+		if name := zoneName(link); name != "" {
+			return name
+		}
+	}
+	if data, err := os.ReadFile("/etc/timezone"); err == nil {
+		if name := zoneName(strings.TrimSpace(string(data))); name != "" {
+			return name
+		}
+	}
+	return time.Now().Format("-07:00")
+}
+
+// zoneName returns the IANA name of a time zone name or a path into the
+// zoneinfo database like /usr/share/zoneinfo/Europe/Berlin, or an empty
+// string if it is no known time zone.
+func zoneName(Zone string) string {
+	if i := strings.LastIndex(Zone, "zoneinfo/"); i >= 0 {
+		Zone = Zone[i+len("zoneinfo/"):]
+	}
+	if Zone == "" || Zone == "localtime" || strings.HasPrefix(Zone, "/") {
+		return ""
+	}
+	if _, err := time.LoadLocation(Zone); err != nil {
+		return ""
+	}
+	return Zone
+}
+
+// Filter wraps the query of the search body in a bool query with the
+// filters. A body without query gets a query matching all documents
+// restricted by the filters. The body is modified and returned.
+func Filter(Body map[string]interface{}, Filters ...Query) map[string]interface{} {
+	if len(Filters) == 0 {
+		return Body
+	}
+	b := Bool().Filter(Filters...)
+	if original, ok := Body["query"].(map[string]interface{}); ok && len(original) > 0 {
+		b.Must(Clause(original))
+	}
+	Body["query"] = b.Source()
+	return Body
+}
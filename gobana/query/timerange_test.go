@@ -0,0 +1,90 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+	_ "time/tzdata"
+)
+
+func TestTimeRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		r     TimeRange
+		want  string
+		fails bool
+	}{
+		{"last", TimeRange{Last: "15m"}, `{"range":{"@timestamp":{"gte":"now-15m","lte":"now"}}}`, false},
+		{"last go duration", TimeRange{Last: "1h30m"}, `{"range":{"@timestamp":{"gte":"now-5400s","lte":"now"}}}`, false},
+		{"date math", TimeRange{Field: "event.created", From: "now-30d/d", To: "now/d"}, `{"range":{"event.created":{"gte":"now-30d/d","lte":"now/d"}}}`, false},
+		{"rfc3339", TimeRange{From: "2024-11-04T10:00:00+01:00"}, `{"range":{"@timestamp":{"gte":"2024-11-04T10:00:00+01:00"}}}`, false},
+		{"local time with zone", TimeRange{From: "2024-11-04 10:00:00", TimeZone: "Europe/Berlin"}, `{"range":{"@timestamp":{"gte":"2024-11-04T10:00:00","time_zone":"Europe/Berlin"}}}`, false},
+		{"anchored", TimeRange{From: "2024-11-04||-1d/d"}, `{"range":{"@timestamp":{"gte":"2024-11-04||-1d/d"}}}`, false},
+		{"epoch", TimeRange{To: "1730714400000"}, `{"range":{"@timestamp":{"lte":"1730714400000"}}}`, false},
+		{"offset", TimeRange{To: "now", TimeZone: "+01:00"}, `{"range":{"@timestamp":{"lte":"now","time_zone":"+01:00"}}}`, false},
+		{"from and last", TimeRange{From: "now-1d", Last: "1h"}, "", true},
+		{"invalid date", TimeRange{From: "yesterday"}, "", true},
+		{"invalid math", TimeRange{From: "now-1x"}, "", true},
+		{"invalid duration", TimeRange{Last: "soon"}, "", true},
+		{"invalid zone", TimeRange{To: "now", TimeZone: "Mars/Olympus"}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := test.r.Query()
+			if test.fails {
+				if err == nil {
+					t.Errorf("Query() = %v, want error", q.Source())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := json.Marshal(q.Source())
+			if string(data) != test.want {
+				t.Errorf("got  %s\nwant %s", data, test.want)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{}`, `{"query":{"bool":{"filter":[{"exists":{"field":"x"}}]}}}`},
+		{`{"size":5,"query":{"match":{"a":"b"}}}`, `{"query":{"bool":{"filter":[{"exists":{"field":"x"}}],"must":[{"match":{"a":"b"}}]}},"size":5}`},
+	}
+	for _, test := range tests {
+		var body map[string]interface{}
+		if err := json.Unmarshal([]byte(test.body), &body); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(Filter(body, Exists("x")))
+		if string(data) != test.want {
+			t.Errorf("Filter(%s) = %s, want %s", test.body, data, test.want)
+		}
+	}
+}
+
+func TestLocalZone(t *testing.T) {
+	tests := []struct {
+		tz   string
+		want string
+	}{
+		{"America/New_York", "America/New_York"},
+		{":Europe/Berlin", "Europe/Berlin"},
+		{"/usr/share/zoneinfo/Asia/Tokyo", "Asia/Tokyo"},
+		{"", "UTC"},
+	}
+	for _, test := range tests {
+		t.Setenv("TZ", test.tz)
+		zone, err := timeZone("local")
+		if err != nil || zone != test.want {
+			t.Errorf("TZ=%s: timeZone(local) = %q, %v, want %q", test.tz, zone, err, test.want)
+		}
+	}
+	if name := zoneName("/etc/zoneinfo/Nowhere/City"); name != "" {
+		t.Errorf("zoneName of an unknown zone = %q, want empty", name)
+	}
+}
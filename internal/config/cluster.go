@@ -0,0 +1,171 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ConnectionSettings are the settings which can be overridden per cluster
+// profile.
+var ConnectionSettings = []string{"ssl", "validatessl", "host", "port", "user", "password", "proxy", "socks", "timeout"}
+
+// baseSettings holds the connection settings before a cluster profile was
+// applied.
+var baseSettings = make(map[string]interface{})
+
+// ClusterNames returns the names of all cluster profiles in the
+// configuration file.
+func ClusterNames() []string {
+	var names []string
+	for name := range viper.GetStringMap("clusters") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Settings returns the connection settings for the named cluster profile.
+// Settings given on the command line take precedence over the profile, the
+// profile over the top level settings of the configuration. An empty name
+// returns the top level settings.
+func Settings(Name string) (map[string]interface{}, error) {
+	settings := make(map[string]interface{}, len(ConnectionSettings))
+	for _, key := range ConnectionSettings {
+		settings[key] = baseSettings[key]
+	}
+	if Name == "" {
+		return settings, nil
+	}
+	if !viper.IsSet("clusters." + Name) {
+		return nil, errors.New("Unknown cluster '" + Name + "'")
+	}
+	profile := viper.GetStringMap("clusters." + Name)
+	for _, key := range ConnectionSettings {
+		value, ok := profile[key]
+		if !ok || flags != nil && flags.Changed(key) {
+			continue
+		}
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+// DefaultCluster returns the cluster set in the configuration file.
+func DefaultCluster() string {
+	v := viper.New()
+	v.SetConfigFile(viper.ConfigFileUsed())
+	if err := v.ReadInConfig(); err != nil {
+		return ""
+	}
+	return v.GetString("cluster")
+}
+
+// UseCluster makes the named cluster profile the default by setting it in
+// the configuration file. In yaml and toml files, only the cluster line is
+// changed or added, other formats are rewritten.
+func UseCluster(Name string) error {
+	logger := log.WithField("func", "config.UseCluster")
+
+	if _, err := Settings(Name); err != nil {
+		logger.Error(err)
+		return err
+	}
+	file := viper.ConfigFileUsed()
+	info, err := os.Stat(file)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+		value := Name
+		if !plainValue.MatchString(Name) {
+			value = strconv.Quote(Name)
+		}
+		data = setLine(data, yamlClusterLine, "cluster: "+value, false)
+	case ".toml":
+		data = setLine(data, tomlClusterLine, "cluster = "+strconv.Quote(Name), true)
+	default:
+		v := viper.New()
+		v.SetConfigFile(file)
+		err = v.ReadInConfig()
+		if err == nil {
+			v.Set("cluster", Name)
+			err = v.WriteConfig()
+		}
+		if err != nil {
+			logger.Error(err)
+		}
+		return err
+	}
+	err = os.WriteFile(file, data, info.Mode().Perm())
+	if err != nil {
+		logger.Error(err)
+	}
+	return err
+}
+
+var (
+	plainValue      = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+	yamlClusterLine = regexp.MustCompile(`(?m)^cluster[ \t]*:[^#\r\n]*?([ \t]+#[^\r\n]*)?$`)
+	tomlClusterLine = regexp.MustCompile(`(?m)^cluster[ \t]*=[^#\r\n]*?([ \t]+#[^\r\n]*)?$`)
+)
+
+// setLine replaces the first line matching Pattern with Line, keeping a
+// trailing comment. Without a match, Line is added at the start of Data if
+// Prepend is set, at the end otherwise.
+func setLine(Data []byte, Pattern *regexp.Regexp, Line string, Prepend bool) []byte {
+	if match := Pattern.FindSubmatchIndex(Data); match != nil {
+		line := Line
+		if match[2] >= 0 {
+			line += string(Data[match[2]:match[3]])
+		}
+		result := append([]byte{}, Data[:match[0]]...)
+		result = append(result, line...)
+		return append(result, Data[match[1]:]...)
+	}
+	if Prepend {
+		return append([]byte(Line+"\n"), Data...)
+	}
+	if len(Data) > 0 && Data[len(Data)-1] != '\n' {
+		Data = append(Data, '\n')
+	}
+	return append(Data, Line+"\n"...)
+}
+
+// applyClusterProfile overrides the connection settings with those of the
+// cluster profile selected by --cluster or the "cluster" setting.
+func applyClusterProfile() error {
+	logger := log.WithField("func", "config.applyClusterProfile")
+
+	for _, key := range ConnectionSettings {
+		baseSettings[key] = viper.Get(key)
+	}
+	name := viper.GetString("cluster")
+	if name == "" {
+		return nil
+	}
+	settings, err := Settings(name)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+	logger.WithField("cluster", name).Debug("Applied cluster profile")
+	return nil
+}
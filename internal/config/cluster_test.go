@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const testConfig = `# connection to the local cluster
+host: localhost
+user: admin
+cluster: dev # the default
+clusters:
+  dev:
+    host: es-dev.example.com
+  prod:
+    host: es-prod.example.com
+    port: 9243
+    ssl: true
+`
+
+// loadConfig writes Content to a configuration file and reads it like
+// gobana with the command line Args.
+func loadConfig(t *testing.T, Name string, Content string, Args ...string) string {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	file := filepath.Join(t.TempDir(), Name)
+	if err := os.WriteFile(file, []byte(Content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	command := &cobra.Command{Use: "gobana"}
+	AddFlags(command, "gobana")
+	if err := command.ParseFlags(append([]string{"--config", file}, Args...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := HandleConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestClusterProfilePrecedence(t *testing.T) {
+	tests := []struct {
+		args []string
+		host string
+		port int
+		ssl  bool
+		user string
+	}{
+		{nil, "es-dev.example.com", 9200, false, "admin"},
+		{[]string{"--cluster", "prod"}, "es-prod.example.com", 9243, true, "admin"},
+		{[]string{"--cluster", "prod", "--port", "9300", "--user", "ops"}, "es-prod.example.com", 9300, true, "ops"},
+		{[]string{"--host", "es-local"}, "es-local", 9200, false, "admin"},
+	}
+	for _, test := range tests {
+		loadConfig(t, "gobana.yml", testConfig, test.args...)
+		host, port, ssl, user := viper.GetString("host"), viper.GetInt("port"), viper.GetBool("ssl"), viper.GetString("user")
+		if host != test.host || port != test.port || ssl != test.ssl || user != test.user {
+			t.Errorf("%v: host, port, ssl, user = %s, %d, %v, %s, want %s, %d, %v, %s",
+				test.args, host, port, ssl, user, test.host, test.port, test.ssl, test.user)
+		}
+	}
+}
+
+func TestSettings(t *testing.T) {
+	loadConfig(t, "gobana.yml", testConfig, "--cluster", "prod", "--port", "9300")
+	settings, err := Settings("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["host"] != "es-dev.example.com" || settings["port"] != 9300 || settings["user"] != "admin" {
+		t.Errorf("Settings(dev) = %v", settings)
+	}
+	settings, err = Settings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["host"] != "localhost" {
+		t.Errorf("Settings() host = %v, want localhost", settings["host"])
+	}
+	if _, err := Settings("missing"); err == nil {
+		t.Error("Settings(missing) succeeded, want error")
+	}
+	if DefaultCluster() != "dev" {
+		t.Errorf("DefaultCluster = %q, want dev", DefaultCluster())
+	}
+}
+
+func TestUseCluster(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		cluster string
+		want    string
+	}{
+		{"gobana.yml", testConfig, "prod", `# connection to the local cluster
+host: localhost
+user: admin
+cluster: prod # the default
+clusters:
+  dev:
+    host: es-dev.example.com
+  prod:
+    host: es-prod.example.com
+    port: 9243
+    ssl: true
+`},
+		{"gobana.yaml", "# no default\nclusters:\n  prod east:\n    host: es-prod\n", "prod east",
+			"# no default\nclusters:\n  prod east:\n    host: es-prod\ncluster: \"prod east\"\n"},
+		{"gobana.toml", "# profiles\ncluster = \"dev\"\n\n[clusters.dev]\nhost = \"es-dev\"\n[clusters.prod]\nhost = \"es-prod\"\n", "prod",
+			"# profiles\ncluster = \"prod\"\n\n[clusters.dev]\nhost = \"es-dev\"\n[clusters.prod]\nhost = \"es-prod\"\n"},
+		{"gobana.toml", "[clusters.prod]\nhost = \"es-prod\"\n", "prod",
+			"cluster = \"prod\"\n[clusters.prod]\nhost = \"es-prod\"\n"},
+	}
+	for _, test := range tests {
+		file := loadConfig(t, test.name, test.content)
+		if err := UseCluster(test.cluster); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.want {
+			t.Errorf("%s after UseCluster(%s):\n%s\nwant:\n%s", test.name, test.cluster, data, test.want)
+		}
+	}
+	if err := UseCluster("missing"); err == nil {
+		t.Error("UseCluster(missing) succeeded, want error")
+	}
+}
@@ -0,0 +1,199 @@
+// Package config holds the connection settings, configuration file
+// handling, logging setup and the construction of the Elasticsearch
+// connection shared by all tools.
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/joernott/lra"
+	homedir "github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var ConfigFile string
+var Cluster string
+var UseSSL bool
+var ValidateSSL bool
+var Host string
+var Port int
+var User string
+var Password string
+var LogLevel int
+var LogFile string
+var Proxy string
+var ProxyIsSocks bool
+var Timeout uint
+
+// configName is the name of the configuration file without extension.
+var configName string
+
+// flags are the persistent flags of the root command, used to find
+// settings given on the command line.
+var flags *pflag.FlagSet
+
+// AddFlags adds the configuration, connection and logging flags to the
+// persistent flags of the root command of a tool and binds them to viper.
+// Name is the name of the configuration file without the extension.
+func AddFlags(Command *cobra.Command, Name string) {
+	configName = Name
+	flags = Command.PersistentFlags()
+	flags.StringVarP(&ConfigFile, "config", "c", "", "Configuration file (default "+Name+".yml in the current working directory, the home directory or next to the executable)")
+	flags.StringVarP(&Cluster, "cluster", "C", "", "Cluster profile from the clusters section of the configuration file")
+	flags.BoolVarP(&UseSSL, "ssl", "s", false, "Use SSL")
+	flags.BoolVarP(&ValidateSSL, "validatessl", "v", true, "Validate SSL certificate")
+	flags.StringVarP(&Host, "host", "H", "localhost", "Hostname of the server")
+	flags.IntVarP(&Port, "port", "P", 9200, "Network port")
+	flags.StringVarP(&User, "user", "u", "", "Username for Elasticsearch")
+	flags.StringVarP(&Password, "password", "p", "", "Password for the Elasticsearch user")
+	flags.IntVarP(&LogLevel, "loglevel", "l", 5, "Log level")
+	flags.StringVarP(&LogFile, "logfile", "L", "", "Log file (defaults to stdout)")
+	flags.StringVarP(&Proxy, "proxy", "y", "", "Proxy (defaults to none)")
+	flags.BoolVarP(&ProxyIsSocks, "socks", "Y", false, "This is a SOCKS proxy")
+	flags.UintVarP(&Timeout, "timeout", "T", 60, "Timeout in seconds")
+
+	viper.SetDefault("cluster", "")
+	viper.SetDefault("ssl", false)
+	viper.SetDefault("validatessl", true)
+	viper.SetDefault("host", "localhost")
+	viper.SetDefault("port", 9200)
+	viper.SetDefault("user", "")
+	viper.SetDefault("password", "")
+	viper.SetDefault("loglevel", 5)
+	viper.SetDefault("logfile", "")
+	viper.SetDefault("proxy", "")
+	viper.SetDefault("socks", false)
+	viper.SetDefault("timeout", 60)
+
+	viper.BindPFlag("cluster", flags.Lookup("cluster"))
+	viper.BindPFlag("ssl", flags.Lookup("ssl"))
+	viper.BindPFlag("validatessl", flags.Lookup("validatessl"))
+	viper.BindPFlag("host", flags.Lookup("host"))
+	viper.BindPFlag("port", flags.Lookup("port"))
+	viper.BindPFlag("user", flags.Lookup("user"))
+	viper.BindPFlag("password", flags.Lookup("password"))
+	viper.BindPFlag("loglevel", flags.Lookup("loglevel"))
+	viper.BindPFlag("logfile", flags.Lookup("logfile"))
+	viper.BindPFlag("proxy", flags.Lookup("proxy"))
+	viper.BindPFlag("socks", flags.Lookup("socks"))
+	viper.BindPFlag("timeout", flags.Lookup("timeout"))
+}
+
+// HandleConfigFile reads the configuration file given by --config or
+// searches for it in the current working directory, the home directory
+// and the directory of the executable. Afterwards, the selected cluster
+// profile is applied.
+func HandleConfigFile() error {
+	if ConfigFile != "" {
+		log.Debug("Read config from " + ConfigFile)
+		viper.SetConfigFile(ConfigFile)
+	} else {
+		log.Debug("Search config in the working directory, home directory and next to the executable")
+		pwd, err := os.Getwd()
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		viper.AddConfigPath(pwd)
+		home, err := homedir.Dir()
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		viper.AddConfigPath(home)
+		ex, err := os.Executable()
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		viper.AddConfigPath(filepath.Dir(ex))
+		viper.SetConfigName(configName)
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		log.Error("Can't read config: " + err.Error())
+		return err
+	}
+
+	return applyClusterProfile()
+}
+
+// InitLogging configures the log output and level from the settings.
+func InitLogging() error {
+	LogFile = viper.GetString("logfile")
+	LogLevel = viper.GetInt("loglevel")
+	if LogFile == "" {
+		log.SetOutput(os.Stdout)
+	} else {
+		f, err := os.Create(LogFile)
+		if err != nil {
+			fmt.Println("Could not create logfile '" + LogFile + "'")
+			return err
+		}
+		log.SetOutput(f)
+	}
+	switch LogLevel {
+	case 0:
+		log.SetLevel(log.PanicLevel)
+	case 1:
+		log.SetLevel(log.FatalLevel)
+	case 2:
+		log.SetLevel(log.ErrorLevel)
+	case 3:
+		log.SetLevel(log.WarnLevel)
+	case 4:
+		log.SetLevel(log.InfoLevel)
+	case 5:
+		log.SetLevel(log.DebugLevel)
+	default:
+		log.SetLevel(log.DebugLevel)
+	}
+	log.WithFields(log.Fields{
+		"LogFile":  LogFile,
+		"LogLevel": LogLevel,
+	}).Debug("Logging configured")
+	return nil
+}
+
+// NewConnection creates the connection to Elasticsearch from the settings.
+func NewConnection() (*lra.Connection, error) {
+	logger := log.WithField("func", "config.NewConnection")
+
+	hdr := make(lra.HeaderList)
+	hdr["Content-Type"] = "application/json"
+	// The arguments are passed like gobana always did: user and password
+	// before the empty base endpoint and the timeout unscaled, in seconds.
+	connection, err := lra.NewConnection(
+		viper.GetBool("ssl"),
+		viper.GetString("host"),
+		viper.GetInt("port"),
+		viper.GetString("user"),
+		viper.GetString("password"),
+		"",
+		viper.GetBool("validatessl"),
+		viper.GetString("proxy"),
+		viper.GetBool("socks"),
+		hdr,
+		time.Duration(viper.GetUint("timeout")),
+	)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	logger.WithFields(log.Fields{
+		"Protocol":     connection.Protocol,
+		"Server":       connection.Server,
+		"Port":         connection.Port,
+		"User":         connection.User,
+		"ValidateSSL":  connection.ValidateSSL,
+		"Proxy":        connection.Proxy,
+		"ProxyIsSocks": connection.ProxyIsSocks,
+		"BaseURL":      connection.BaseURL,
+	}).Debug("Elasticsearch connection initialized")
+	return connection, nil
+}